@@ -0,0 +1,61 @@
+package volumedriver
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/goshims/timeshim"
+)
+
+// Clock is a timeshim.Time that also drives the driver's timers: the health
+// monitor's interval and the teardown of lingering mounts. When the time
+// given to NewVolumeDriver implements it, those timers follow the same clock
+// as the timestamps the driver records. Otherwise they use the real clock.
+type Clock interface {
+	timeshim.Time
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Ticker is the part of a *time.Ticker that the driver uses.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is the part of a *time.Timer that the driver uses.
+type Timer interface {
+	Stop() bool
+}
+
+func newClock(t timeshim.Time) Clock {
+	if clock, ok := t.(Clock); ok {
+		return clock
+	}
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
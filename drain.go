@@ -0,0 +1,244 @@
+package volumedriver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/dockerdriver/driverhttp"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+	"code.cloudfoundry.org/volumedriver/metrics"
+)
+
+// DefaultDrainConcurrency is how many volumes Drain unmounts at once.
+const DefaultDrainConcurrency = 4
+
+// WithDrainConcurrency sets how many volumes Drain unmounts at once.
+// Defaults to DefaultDrainConcurrency.
+func WithDrainConcurrency(n int) Option {
+	return func(d *VolumeDriver) {
+		if n < 1 {
+			n = 1
+		}
+		d.drainConcurrency = n
+	}
+}
+
+// DrainResult reports what Drain did with every mounted volume.
+type DrainResult struct {
+	// Unmounted volumes were unmounted normally, or were no longer mounted.
+	Unmounted []string
+	// Forced volumes did not unmount in time and were forced or lazily
+	// detached instead.
+	Forced []string
+	// Failed volumes may still be mounted.
+	Failed []DrainFailure
+}
+
+// DrainFailure is a volume that Drain could not unmount.
+type DrainFailure struct {
+	Volume     string
+	Mountpoint string
+	Err        error
+}
+
+// Err returns a *DrainError if any volume failed to unmount.
+func (r DrainResult) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	return &DrainError{Result: r}
+}
+
+// DrainError is returned by Drain when volumes may still be mounted.
+type DrainError struct {
+	Result DrainResult
+}
+
+func (e *DrainError) Error() string {
+	failures := make([]string, 0, len(e.Result.Failed))
+	for _, failure := range e.Result.Failed {
+		failures = append(failures, fmt.Sprintf("%s: %s", failure.Volume, failure.Err))
+	}
+	return fmt.Sprintf("failed to unmount %d volume(s) while draining: %s", len(e.Result.Failed), strings.Join(failures, "; "))
+}
+
+func (e *DrainError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Result.Failed))
+	for _, failure := range e.Result.Failed {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+type drainOutcome int
+
+const (
+	drainSkipped drainOutcome = iota
+	drainUnmounted
+	drainForced
+	drainFailed
+)
+
+// Drain unmounts every volume and forgets it, and releases the state lock.
+// It returns a *DrainError if any volume may still be mounted.
+func (d *VolumeDriver) Drain(env dockerdriver.Env) error {
+	return d.DrainVolumes(env).Err()
+}
+
+// DrainVolumes is Drain, returning what happened to each volume. Volumes are
+// unmounted in parallel. Failed unmounts, and any still unmounting when the
+// env's context is done, are escalated as set by the UnmountPolicy. Volumes
+// still waiting their turn at that point are forced straight away, and those
+// held by another operation, such as a hung Mount, fail.
+func (d *VolumeDriver) DrainVolumes(env dockerdriver.Env) DrainResult {
+	logger := env.Logger().Session("check-mounts")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	start := d.time.Now()
+
+	// Stop the monitor first so that it cannot remount what is being drained.
+	d.stopHealth()
+	// Lingering mounts are unmounted below rather than when their time is up.
+	d.stopTeardowns()
+
+	names := d.volumes.Keys()
+	outcomes := make([]drainOutcome, len(names))
+	failures := make([]DrainFailure, len(names))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.drainConcurrency)
+	for i, name := range names {
+		// Past the deadline nothing waits for a slot, as drainVolume only
+		// forces the mount then.
+		slotted := true
+		select {
+		case slots <- struct{}{}:
+		case <-env.Context().Done():
+			slotted = false
+		}
+
+		wg.Add(1)
+		go func(i int, name string, slotted bool) {
+			defer wg.Done()
+			if slotted {
+				defer func() { <-slots }()
+			}
+			outcomes[i], failures[i] = d.drainVolume(driverhttp.EnvWithLogger(logger, env), name)
+		}(i, name, slotted)
+	}
+	wg.Wait()
+
+	var result DrainResult
+	for i, name := range names {
+		switch outcomes[i] {
+		case drainUnmounted:
+			result.Unmounted = append(result.Unmounted, name)
+		case drainForced:
+			result.Forced = append(result.Forced, name)
+		case drainFailed:
+			result.Failed = append(result.Failed, failures[i])
+		}
+	}
+	sort.Strings(result.Unmounted)
+	sort.Strings(result.Forced)
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Volume < result.Failed[j].Volume })
+
+	d.mounter.Purge(env, d.mountPathRoot)
+
+	if err := d.releaseStateLock(); err != nil {
+		logger.Error("release-state-lock-failed", err)
+	}
+
+	outcome := metrics.OutcomeSuccess
+	if len(result.Failed) > 0 {
+		outcome = metrics.OutcomeFailure
+	}
+	d.metrics.ObserveDrain(outcome, d.time.Now().Sub(start), len(result.Failed))
+	d.recordVolumeGauges()
+
+	logger.Info("drained", lager.Data{"unmounted": result.Unmounted, "forced": result.Forced, "failed": len(result.Failed)})
+	return result
+}
+
+// drainVolume unmounts a volume and forgets it. A normal unmount that is
+// still running when the context is done is left running, and the mount is
+// forced instead. A volume whose lock is still held by another operation when
+// the context is done fails, and is left alone.
+func (d *VolumeDriver) drainVolume(env dockerdriver.Env, name string) (drainOutcome, DrainFailure) {
+	logger := env.Logger().Session("drain-volume", lager.Data{"volume": name})
+
+	unlock, err := d.volumeLocks.LockContext(env.Context(), name)
+	if err != nil {
+		volume, _ := d.volumes.Get(name)
+		logger.Error("volume-busy-past-deadline", err, lager.Data{"mountpoint": volume.Mountpoint})
+		return drainFailed, DrainFailure{Volume: name, Mountpoint: volume.Mountpoint, Err: &drivererrors.UnmountFailedError{Volume: name, Err: fmt.Errorf("volume is busy: %w", err)}}
+	}
+	defer unlock()
+
+	volume, ok := d.volumes.Get(name)
+	if !ok {
+		return drainSkipped, DrainFailure{}
+	}
+	defer func() {
+		d.volumes.Delete(name)
+		d.mountRecords.Delete(name)
+		d.cancelTeardown(name)
+	}()
+
+	if volume.Mountpoint == "" || (volume.MountCount < 1 && !lingering(volume)) {
+		return drainSkipped, DrainFailure{}
+	}
+
+	type unmounted struct {
+		forced bool
+		err    error
+	}
+
+	ctx := env.Context()
+	if err := ctx.Err(); err == nil {
+		done := make(chan unmounted, 1)
+		go func() {
+			forced, err := d.unmountVolume(env, name, volume.Mountpoint)
+			done <- unmounted{forced: forced, err: err}
+		}()
+
+		select {
+		case result := <-done:
+			var notMounted *drivererrors.NotMountedError
+			switch {
+			case result.err == nil && result.forced:
+				return drainForced, DrainFailure{}
+			case result.err == nil, errors.As(result.err, &notMounted):
+				return drainUnmounted, DrainFailure{}
+			}
+			logger.Error("drain-unmount-failed", result.err, lager.Data{"mount-name": name, "mount-point": volume.Mountpoint})
+			return drainFailed, DrainFailure{Volume: name, Mountpoint: volume.Mountpoint, Err: result.err}
+		case <-ctx.Done():
+		}
+	}
+
+	// The normal unmount is left running, and the mount is forced instead.
+	logger.Info("unmount-overran-deadline", lager.Data{"mountpoint": volume.Mountpoint})
+	if err := d.escalateUnmount(env, name, volume.Mountpoint); err != nil {
+		err = d.redactor.Error(err)
+		if errors.Is(err, errFlagUnmountUnsupported) {
+			err = ctx.Err()
+		}
+		logger.Error("drain-unmount-failed", err, lager.Data{"mount-name": name, "mount-point": volume.Mountpoint})
+		return drainFailed, DrainFailure{Volume: name, Mountpoint: volume.Mountpoint, Err: &drivererrors.UnmountFailedError{Volume: name, Err: err}}
+	}
+
+	if err := d.removeMountPath(logger, name, volume.Mountpoint, "after-forced-unmount"); err != nil {
+		var mountPointNotExistErr *MountPointNotExistError
+		if !errors.As(err, &mountPointNotExistErr) {
+			logger.Error("remove-mountpoint-failed", err)
+		}
+	}
+	return drainForced, DrainFailure{}
+}
@@ -0,0 +1,44 @@
+package volumedriver
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/volumedriver/metrics"
+)
+
+// WithMetricsRecorder sets where the driver records metrics about its
+// operations, for example a metrics.PrometheusRecorder. Defaults to
+// metrics.Discard.
+func WithMetricsRecorder(r metrics.Recorder) Option {
+	return func(d *VolumeDriver) {
+		d.metrics = r
+	}
+}
+
+// observeOperation is deferred by every dockerdriver.Driver method with a
+// pointer to the Err of its response, so that the outcome is known when it
+// runs.
+func (d *VolumeDriver) observeOperation(operation string, start time.Time, errText *string) {
+	outcome := metrics.OutcomeSuccess
+	if *errText != "" {
+		outcome = metrics.OutcomeFailure
+	}
+	d.metrics.ObserveOperation(operation, outcome, d.time.Now().Sub(start))
+	d.recordVolumeGauges()
+}
+
+func (d *VolumeDriver) recordVolumeGauges() {
+	active, total := d.volumes.Count(func(volume NfsVolumeInfo) bool {
+		return volume.MountCount > 0 || lingering(volume)
+	})
+
+	d.metrics.SetVolumes(total)
+	d.metrics.SetActiveMounts(active)
+}
+
+func outcomeOf(err error) metrics.Outcome {
+	if err != nil {
+		return metrics.OutcomeFailure
+	}
+	return metrics.OutcomeSuccess
+}
@@ -0,0 +1,13 @@
+package drivererrors_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDrivererrors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Drivererrors Suite")
+}
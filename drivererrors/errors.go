@@ -0,0 +1,273 @@
+// Package drivererrors defines the errors returned by the volume driver, and
+// how they are written into the Err field of its responses.
+//
+// Every error has a stable Code that callers can switch on, and a safe
+// description that may be shown to the user. The Err field is the JSON of a
+// Response, which decodes as a dockerdriver.SafeError so that existing
+// callers keep showing the safe description.
+package drivererrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+)
+
+// Code identifies the kind of a driver error. Codes are stable and never
+// reused for a different meaning.
+type Code string
+
+const (
+	CodeNotFound         Code = "not-found"
+	CodeInvalidName      Code = "invalid-name"
+	CodeInvalidOptions   Code = "invalid-options"
+	CodeNotMounted       Code = "not-mounted"
+	CodeMountFailed      Code = "mount-failed"
+	CodeMountTimeout     Code = "mount-timeout"
+	CodeUnmountFailed    Code = "unmount-failed"
+	CodeStatePersistence Code = "state-persistence"
+	CodePermissionDenied Code = "permission-denied"
+	CodeStaleMount       Code = "stale-mount"
+	// CodeUnknown is used for errors that are not one of the types below.
+	CodeUnknown Code = "unknown"
+)
+
+// Error is implemented by every error type in this package.
+type Error interface {
+	error
+	Code() Code
+	// SafeDescription is a description of the error that is safe to show to
+	// the user. It never contains option values or server responses.
+	SafeDescription() string
+}
+
+// UserError reports whether code is caused by the request rather than by the
+// driver or the infrastructure it depends on.
+func UserError(code Code) bool {
+	switch code {
+	case CodeNotFound, CodeInvalidName, CodeInvalidOptions, CodeNotMounted:
+		return true
+	default:
+		return false
+	}
+}
+
+// NotFoundError is returned when a request names a volume that has not been
+// created.
+type NotFoundError struct {
+	Volume string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("volume '%s' not found", e.Volume)
+}
+func (e *NotFoundError) Code() Code              { return CodeNotFound }
+func (e *NotFoundError) SafeDescription() string { return e.Error() }
+
+// InvalidNameError is returned when a request has a missing or unsafe volume
+// name.
+type InvalidNameError struct {
+	Volume string
+}
+
+func (e *InvalidNameError) Error() string {
+	if e.Volume == "" {
+		return "Missing mandatory 'volume_name'"
+	}
+	return fmt.Sprintf("invalid volume name: %s", e.Volume)
+}
+func (e *InvalidNameError) Code() Code              { return CodeInvalidName }
+func (e *InvalidNameError) SafeDescription() string { return e.Error() }
+
+// InvalidOptionsError is returned when Create is given options the driver
+// cannot use. Reason must not contain option values that may be secret.
+type InvalidOptionsError struct {
+	Volume string
+	Reason string
+}
+
+func (e *InvalidOptionsError) Error() string           { return e.Reason }
+func (e *InvalidOptionsError) Code() Code              { return CodeInvalidOptions }
+func (e *InvalidOptionsError) SafeDescription() string { return e.Reason }
+
+// NotMountedError is returned when a volume has no mount to report or
+// remove.
+type NotMountedError struct {
+	Volume     string
+	Mountpoint string
+	Err        error
+}
+
+func (e *NotMountedError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("volume '%s' is not mounted: %s", e.Volume, e.Err)
+	}
+	return fmt.Sprintf("volume '%s' is not mounted", e.Volume)
+}
+func (e *NotMountedError) Unwrap() error { return e.Err }
+func (e *NotMountedError) Code() Code    { return CodeNotMounted }
+func (e *NotMountedError) SafeDescription() string {
+	return fmt.Sprintf("volume '%s' is not mounted", e.Volume)
+}
+
+// MountFailedError is returned when the Mounter fails to mount a volume. If
+// the Mounter returned a dockerdriver.SafeError its description is passed on
+// to the user.
+type MountFailedError struct {
+	Volume string
+	Err    error
+}
+
+func (e *MountFailedError) Error() string {
+	return fmt.Sprintf("failed to mount volume '%s': %s", e.Volume, e.Err)
+}
+func (e *MountFailedError) Unwrap() error { return e.Err }
+func (e *MountFailedError) Code() Code    { return CodeMountFailed }
+func (e *MountFailedError) SafeDescription() string {
+	var safe dockerdriver.SafeError
+	if errors.As(e.Err, &safe) {
+		return safe.SafeDescription
+	}
+	return fmt.Sprintf("failed to mount volume '%s'", e.Volume)
+}
+
+// MountTimeoutError is returned when a mount does not finish before the
+// request's deadline.
+type MountTimeoutError struct {
+	Volume  string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *MountTimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s", e.SafeDescription(), e.Err)
+}
+func (e *MountTimeoutError) Unwrap() error { return e.Err }
+func (e *MountTimeoutError) Code() Code    { return CodeMountTimeout }
+func (e *MountTimeoutError) SafeDescription() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("timed out after %s mounting volume '%s'", e.Timeout, e.Volume)
+	}
+	return fmt.Sprintf("timed out mounting volume '%s'", e.Volume)
+}
+
+// UnmountFailedError is returned when a volume cannot be unmounted, or its
+// mountpoint cannot be removed.
+type UnmountFailedError struct {
+	Volume string
+	Err    error
+}
+
+func (e *UnmountFailedError) Error() string {
+	return fmt.Sprintf("failed to unmount volume '%s': %s", e.Volume, e.Err)
+}
+func (e *UnmountFailedError) Unwrap() error { return e.Err }
+func (e *UnmountFailedError) Code() Code    { return CodeUnmountFailed }
+func (e *UnmountFailedError) SafeDescription() string {
+	return fmt.Sprintf("failed to unmount volume '%s'", e.Volume)
+}
+
+// StatePersistenceError is returned when a change cannot be saved. The
+// change is not applied.
+type StatePersistenceError struct {
+	Volume string
+	// Operation is what was being done, e.g. "mounting".
+	Operation string
+	Err       error
+}
+
+func (e *StatePersistenceError) Error() string {
+	return fmt.Sprintf("failed to persist state when %s: %s", e.Operation, e.Err)
+}
+func (e *StatePersistenceError) Unwrap() error { return e.Err }
+func (e *StatePersistenceError) Code() Code    { return CodeStatePersistence }
+func (e *StatePersistenceError) SafeDescription() string {
+	return fmt.Sprintf("failed to persist state when %s", e.Operation)
+}
+
+// PermissionDeniedError is returned when the driver is not allowed to create
+// or change a file or directory it needs.
+type PermissionDeniedError struct {
+	Volume string
+	Path   string
+	Err    error
+}
+
+func (e *PermissionDeniedError) Error() string {
+	return fmt.Sprintf("permission denied on %s: %s", e.Path, e.Err)
+}
+func (e *PermissionDeniedError) Unwrap() error { return e.Err }
+func (e *PermissionDeniedError) Code() Code    { return CodePermissionDenied }
+func (e *PermissionDeniedError) SafeDescription() string {
+	return fmt.Sprintf("permission denied preparing volume '%s'", e.Volume)
+}
+
+// StaleMountError is returned when a volume's mount has gone stale and
+// remounting it fails.
+type StaleMountError struct {
+	Volume string
+	Err    error
+}
+
+func (e *StaleMountError) Error() string {
+	return fmt.Sprintf("error remounting stale volume '%s': %s", e.Volume, e.Err)
+}
+func (e *StaleMountError) Unwrap() error { return e.Err }
+func (e *StaleMountError) Code() Code    { return CodeStaleMount }
+func (e *StaleMountError) SafeDescription() string {
+	return fmt.Sprintf("volume '%s' is stale and could not be remounted", e.Volume)
+}
+
+// Response is how an error is written into a response's Err field.
+type Response struct {
+	dockerdriver.SafeError
+	Code Code `json:"Code"`
+	// Message is the full error, for operators. It is omitted when it adds
+	// nothing to the SafeDescription.
+	Message string `json:"Message,omitempty"`
+}
+
+func (r Response) Error() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return r.SafeDescription
+}
+
+// Encode returns the text to put in a response's Err field, or "" for a nil
+// error. Errors that are not an Error are reported with CodeUnknown and a
+// generic description; their text is kept only as the Message.
+func Encode(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	response := Response{Code: CodeUnknown, SafeError: dockerdriver.SafeError{SafeDescription: "volume driver error"}}
+	var typed Error
+	if errors.As(err, &typed) {
+		response.Code = typed.Code()
+		response.SafeDescription = typed.SafeDescription()
+	}
+	if message := err.Error(); message != response.SafeDescription {
+		response.Message = message
+	}
+
+	data, mErr := json.Marshal(response)
+	if mErr != nil {
+		return err.Error()
+	}
+	return string(data)
+}
+
+// Decode parses the Err field of a response. It returns false if the text
+// was not written by Encode.
+func Decode(text string) (Response, bool) {
+	var response Response
+	if err := json.Unmarshal([]byte(text), &response); err != nil || response.Code == "" {
+		return Response{}, false
+	}
+	return response, true
+}
@@ -0,0 +1,104 @@
+package drivererrors_test
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Driver errors", func() {
+	cause := errors.New("cause")
+
+	DescribeTable("codes and safe descriptions",
+		func(err drivererrors.Error, code drivererrors.Code, safeDescription string) {
+			Expect(err.Code()).To(Equal(code))
+			Expect(err.SafeDescription()).To(Equal(safeDescription))
+		},
+		Entry("not found", &drivererrors.NotFoundError{Volume: "vol"}, drivererrors.CodeNotFound, "volume 'vol' not found"),
+		Entry("missing name", &drivererrors.InvalidNameError{}, drivererrors.CodeInvalidName, "Missing mandatory 'volume_name'"),
+		Entry("invalid name", &drivererrors.InvalidNameError{Volume: "../vol"}, drivererrors.CodeInvalidName, "invalid volume name: ../vol"),
+		Entry("invalid options", &drivererrors.InvalidOptionsError{Volume: "vol", Reason: "no source"}, drivererrors.CodeInvalidOptions, "no source"),
+		Entry("not mounted", &drivererrors.NotMountedError{Volume: "vol", Err: cause}, drivererrors.CodeNotMounted, "volume 'vol' is not mounted"),
+		Entry("mount failed", &drivererrors.MountFailedError{Volume: "vol", Err: cause}, drivererrors.CodeMountFailed, "failed to mount volume 'vol'"),
+		Entry("mount failed safely", &drivererrors.MountFailedError{Volume: "vol", Err: dockerdriver.SafeError{SafeDescription: "server said no"}}, drivererrors.CodeMountFailed, "server said no"),
+		Entry("mount timeout", &drivererrors.MountTimeoutError{Volume: "vol", Timeout: 30 * time.Second, Err: cause}, drivererrors.CodeMountTimeout, "timed out after 30s mounting volume 'vol'"),
+		Entry("unmount failed", &drivererrors.UnmountFailedError{Volume: "vol", Err: cause}, drivererrors.CodeUnmountFailed, "failed to unmount volume 'vol'"),
+		Entry("state persistence", &drivererrors.StatePersistenceError{Volume: "vol", Operation: "mounting", Err: cause}, drivererrors.CodeStatePersistence, "failed to persist state when mounting"),
+		Entry("permission denied", &drivererrors.PermissionDeniedError{Volume: "vol", Path: "/mnt/vol", Err: cause}, drivererrors.CodePermissionDenied, "permission denied preparing volume 'vol'"),
+		Entry("stale mount", &drivererrors.StaleMountError{Volume: "vol", Err: cause}, drivererrors.CodeStaleMount, "volume 'vol' is stale and could not be remounted"),
+	)
+
+	It("keeps the cause for errors.Is and errors.As", func() {
+		err := &drivererrors.StaleMountError{Volume: "vol", Err: &drivererrors.MountFailedError{Volume: "vol", Err: cause}}
+		Expect(errors.Is(err, cause)).To(BeTrue())
+
+		var mountFailed *drivererrors.MountFailedError
+		Expect(errors.As(err, &mountFailed)).To(BeTrue())
+	})
+
+	It("tells user errors from infrastructure errors", func() {
+		Expect(drivererrors.UserError(drivererrors.CodeNotFound)).To(BeTrue())
+		Expect(drivererrors.UserError(drivererrors.CodeInvalidOptions)).To(BeTrue())
+		Expect(drivererrors.UserError(drivererrors.CodeMountFailed)).To(BeFalse())
+		Expect(drivererrors.UserError(drivererrors.CodeStatePersistence)).To(BeFalse())
+	})
+
+	Describe("Encode", func() {
+		It("is empty for no error", func() {
+			Expect(drivererrors.Encode(nil)).To(BeEmpty())
+		})
+
+		It("writes the code, safe description and full message", func() {
+			text := drivererrors.Encode(&drivererrors.MountFailedError{Volume: "vol", Err: cause})
+			Expect(text).To(MatchJSON(`{"SafeDescription":"failed to mount volume 'vol'","Code":"mount-failed","Message":"failed to mount volume 'vol': cause"}`))
+		})
+
+		It("leaves out a message that only repeats the safe description", func() {
+			text := drivererrors.Encode(&drivererrors.NotFoundError{Volume: "vol"})
+			Expect(text).To(MatchJSON(`{"SafeDescription":"volume 'vol' not found","Code":"not-found"}`))
+		})
+
+		It("finds a typed error that has been wrapped", func() {
+			response, ok := drivererrors.Decode(drivererrors.Encode(errors.Join(&drivererrors.NotFoundError{Volume: "vol"})))
+			Expect(ok).To(BeTrue())
+			Expect(response.Code).To(Equal(drivererrors.CodeNotFound))
+		})
+
+		It("does not describe untyped errors to the user", func() {
+			response, ok := drivererrors.Decode(drivererrors.Encode(errors.New("internal detail")))
+			Expect(ok).To(BeTrue())
+			Expect(response.Code).To(Equal(drivererrors.CodeUnknown))
+			Expect(response.SafeDescription).To(Equal("volume driver error"))
+			Expect(response.Message).To(Equal("internal detail"))
+		})
+
+		It("can still be read as a dockerdriver.SafeError", func() {
+			var safe dockerdriver.SafeError
+			Expect(json.Unmarshal([]byte(drivererrors.Encode(&drivererrors.MountFailedError{Volume: "vol", Err: cause})), &safe)).To(Succeed())
+			Expect(safe.SafeDescription).To(Equal("failed to mount volume 'vol'"))
+		})
+	})
+
+	Describe("Decode", func() {
+		It("round-trips an encoded error", func() {
+			response, ok := drivererrors.Decode(drivererrors.Encode(&drivererrors.StatePersistenceError{Operation: "creating", Err: cause}))
+			Expect(ok).To(BeTrue())
+			Expect(response.Code).To(Equal(drivererrors.CodeStatePersistence))
+			Expect(response.SafeDescription).To(Equal("failed to persist state when creating"))
+			Expect(response.Error()).To(Equal("failed to persist state when creating: cause"))
+		})
+
+		It("rejects text that was not encoded", func() {
+			_, ok := drivererrors.Decode("volume not found")
+			Expect(ok).To(BeFalse())
+
+			_, ok = drivererrors.Decode(`{"SafeDescription":"safe-error"}`)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
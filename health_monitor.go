@@ -0,0 +1,220 @@
+package volumedriver
+
+import (
+	"context"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/dockerdriver/driverhttp"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/metrics"
+)
+
+// HealthMonitorConfig configures the optional background health monitor.
+type HealthMonitorConfig struct {
+	// Interval between checks of every mounted volume.
+	Interval time.Duration
+	// InitialBackoff is how long a volume whose remount failed is left alone
+	// before the next attempt. It doubles with every consecutive failure up
+	// to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter spreads backoffs by up to this fraction in either direction, so
+	// that volumes on the same dead server are not all retried at once.
+	Jitter float64
+}
+
+// VolumeHealth is the last known health of a mounted volume.
+type VolumeHealth struct {
+	Healthy             bool
+	LastCheck           time.Time
+	LastError           string    `json:",omitempty"`
+	ConsecutiveFailures int       `json:",omitempty"`
+	NextAttempt         time.Time `json:",omitempty"`
+	Remounts            int       `json:",omitempty"`
+}
+
+// WithHealthMonitor starts a monitor that periodically checks every mounted
+// volume and remounts the ones that have gone stale. It stops on Drain.
+// dockerdriver.VolumeInfo, which Get and List return, has no field for a
+// volume's health, so it is reported by VolumeHealth, VolumesHealth and
+// VolumeStatus instead.
+func WithHealthMonitor(config HealthMonitorConfig) Option {
+	return func(d *VolumeDriver) {
+		config = config.withDefaults()
+		d.healthConfig = &config
+	}
+}
+
+func (c HealthMonitorConfig) withDefaults() HealthMonitorConfig {
+	if c.Interval <= 0 {
+		c.Interval = 30 * time.Second
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = c.Interval
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		c.MaxBackoff = 10 * c.InitialBackoff
+	}
+	return c
+}
+
+// healthMonitorConfig returns the monitor's config, or the defaults when
+// CheckHealth is called on a driver without a monitor.
+func (d *VolumeDriver) healthMonitorConfig() HealthMonitorConfig {
+	if d.healthConfig == nil {
+		return HealthMonitorConfig{}.withDefaults()
+	}
+	return *d.healthConfig
+}
+
+// VolumeHealth returns the last known health of a mounted volume.
+func (d *VolumeDriver) VolumeHealth(name string) (VolumeHealth, bool) {
+	return d.health.Get(name)
+}
+
+// VolumesHealth returns the last known health of every mounted volume.
+func (d *VolumeDriver) VolumesHealth() map[string]VolumeHealth {
+	result := map[string]VolumeHealth{}
+	for _, name := range d.health.Keys() {
+		if h, ok := d.health.Get(name); ok {
+			result[name] = h
+		}
+	}
+	return result
+}
+
+// CheckHealth runs a single pass of the health monitor: every volume with a
+// live refcount is checked, and stale ones are remounted unless they are
+// still backing off from an earlier failure. It may be called on a driver
+// without WithHealthMonitor, in which case the default backoffs apply.
+func (d *VolumeDriver) CheckHealth(env dockerdriver.Env) {
+	logger := env.Logger().Session("check-health")
+	logger.Debug("start")
+	defer logger.Debug("end")
+
+	for _, name := range d.health.Keys() {
+		if volume, ok := d.volumes.Get(name); !ok || volume.MountCount < 1 {
+			d.health.Delete(name)
+		}
+	}
+
+	for _, name := range d.volumes.Keys() {
+		d.checkVolumeHealth(driverhttp.EnvWithLogger(logger, env), name)
+	}
+}
+
+func (d *VolumeDriver) checkVolumeHealth(env dockerdriver.Env, name string) {
+	logger := env.Logger().Session("check-volume", lager.Data{"volume": name})
+
+	unlock := d.volumeLocks.Lock(name)
+	defer unlock()
+
+	volume, ok := d.volumes.Get(name)
+	if !ok || volume.MountCount < 1 || volume.Mountpoint == "" {
+		d.health.Delete(name)
+		return
+	}
+
+	now := d.time.Now()
+	health, _ := d.health.Get(name)
+	if now.Before(health.NextAttempt) {
+		return
+	}
+	health.LastCheck = now
+
+	stale := volume.Degraded || !d.mounter.Check(env, volume.Name, volume.Mountpoint)
+	if !stale {
+		exists, err := d.mountChecker.Exists(volume.Mountpoint)
+		if err != nil {
+			logger.Error("failed-proc-mounts-check", err, lager.Data{"mountpoint": volume.Mountpoint})
+		}
+		stale = err == nil && !exists
+	}
+
+	if !stale {
+		health.Healthy = true
+		health.LastError = ""
+		health.ConsecutiveFailures = 0
+		health.NextAttempt = time.Time{}
+		d.health.Put(name, health)
+		return
+	}
+
+	logger.Info("stale-mount-detected", lager.Data{"mountpoint": volume.Mountpoint})
+	err := d.mount(env, copyOpts(volume.Opts), volume.Mountpoint)
+	d.recordMount(name, now, d.time.Now(), err)
+	d.metrics.ObserveRemount(metrics.RemountOnHealthCheck, outcomeOf(err))
+	if err != nil {
+		err = d.redactor.Error(err)
+		logger.Error("remount-failed", err)
+
+		health.Healthy = false
+		health.LastError = err.Error()
+		health.ConsecutiveFailures++
+		config := d.healthMonitorConfig()
+		health.NextAttempt = now.Add(backoff(config.InitialBackoff, config.MaxBackoff, config.Jitter, health.ConsecutiveFailures))
+		d.health.Put(name, health)
+
+		// A degraded volume is remounted by the next Mount even if the
+		// monitor is still backing off.
+		if !volume.Degraded {
+			volume.Degraded = true
+			d.volumes.Put(name, volume)
+			if err := d.persistVolume(env, name); err != nil {
+				logger.Error("persist-state-failed", err)
+			}
+		}
+		return
+	}
+
+	logger.Info("remounted-volume")
+	health.Healthy = true
+	health.LastError = ""
+	health.ConsecutiveFailures = 0
+	health.NextAttempt = time.Time{}
+	health.Remounts++
+	d.health.Put(name, health)
+
+	if volume.Degraded {
+		volume.Degraded = false
+		d.volumes.Put(name, volume)
+		if err := d.persistVolume(env, name); err != nil {
+			logger.Error("persist-state-failed", err)
+		}
+	}
+}
+
+func (d *VolumeDriver) startHealthMonitor(logger lager.Logger) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.stopHealthMonitor = cancel
+	d.healthMonitorDone = make(chan struct{})
+
+	env := driverhttp.NewHttpDriverEnv(logger.Session("health-monitor"), ctx)
+
+	go func() {
+		defer close(d.healthMonitorDone)
+
+		ticker := d.clock.NewTicker(d.healthConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				d.CheckHealth(env)
+			}
+		}
+	}()
+}
+
+// stopHealth stops the health monitor, if one is running, and waits for any
+// check in progress to finish.
+func (d *VolumeDriver) stopHealth() {
+	if d.stopHealthMonitor == nil {
+		return
+	}
+	d.stopHealthMonitor()
+	<-d.healthMonitorDone
+}
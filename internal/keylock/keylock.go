@@ -0,0 +1,84 @@
+package keylock
+
+import (
+	"context"
+	"sync"
+)
+
+func New() *KeyLock {
+	return &KeyLock{locks: make(map[string]*entry)}
+}
+
+// KeyLock serialises work on a per-key basis: callers holding the lock for
+// one key never block callers working on a different key.
+type KeyLock struct {
+	locks map[string]*entry
+	lock  sync.Mutex
+}
+
+type entry struct {
+	// held has room for one token, which the holder of the lock puts in.
+	held chan struct{}
+	refs int
+}
+
+// Lock blocks until the lock for key is held and returns the function that
+// releases it. Entries are dropped once no caller references them, so the
+// map only ever holds keys that are in use.
+func (k *KeyLock) Lock(key string) (unlock func()) {
+	unlock, _ = k.LockContext(context.Background(), key)
+	return unlock
+}
+
+// LockContext is Lock, giving up with the context's error once ctx is done.
+// A lock that is free is taken even if ctx is already done.
+func (k *KeyLock) LockContext(ctx context.Context, key string) (unlock func(), err error) {
+	e := k.ref(key)
+
+	select {
+	case e.held <- struct{}{}:
+	default:
+		select {
+		case e.held <- struct{}{}:
+		case <-ctx.Done():
+			k.unref(key, e)
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		<-e.held
+		k.unref(key, e)
+	}, nil
+}
+
+func (k *KeyLock) ref(key string) *entry {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	e, ok := k.locks[key]
+	if !ok {
+		e = &entry{held: make(chan struct{}, 1)}
+		k.locks[key] = e
+	}
+	e.refs++
+	return e
+}
+
+func (k *KeyLock) unref(key string, e *entry) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	e.refs--
+	if e.refs == 0 {
+		delete(k.locks, key)
+	}
+}
+
+// Len returns the number of keys that are currently locked or waited on.
+func (k *KeyLock) Len() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	return len(k.locks)
+}
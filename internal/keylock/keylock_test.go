@@ -0,0 +1,106 @@
+package keylock_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/volumedriver/internal/keylock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KeyLock", func() {
+	It("serialises callers using the same key", func() {
+		k := keylock.New()
+
+		const workers = 100
+		var (
+			wg      sync.WaitGroup
+			holders int32
+			counter int
+		)
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				unlock := k.Lock("key")
+				defer unlock()
+
+				Expect(atomic.AddInt32(&holders, 1)).To(Equal(int32(1)))
+				counter++
+				atomic.AddInt32(&holders, -1)
+			}()
+		}
+
+		wg.Wait()
+		Expect(counter).To(Equal(workers))
+	})
+
+	It("does not block callers using a different key", func() {
+		k := keylock.New()
+		unlock := k.Lock("foo")
+		defer unlock()
+
+		done := make(chan struct{})
+		go func() {
+			k.Lock("bar")()
+			close(done)
+		}()
+
+		Eventually(done, time.Second).Should(BeClosed())
+	})
+
+	It("forgets keys once they are released", func() {
+		k := keylock.New()
+		unlockFoo := k.Lock("foo")
+		unlockBar := k.Lock("bar")
+		Expect(k.Len()).To(Equal(2))
+
+		unlockFoo()
+		unlockBar()
+		Expect(k.Len()).To(BeZero())
+	})
+
+	Describe("LockContext", func() {
+		It("gives up once the context is done", func() {
+			k := keylock.New()
+			unlock := k.Lock("key")
+			defer unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_, err := k.LockContext(ctx, "key")
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("still takes a free lock once the context is done", func() {
+			k := keylock.New()
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			unlock, err := k.LockContext(ctx, "key")
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+			Expect(k.Len()).To(BeZero())
+		})
+
+		It("leaves the lock usable by others after giving up", func() {
+			k := keylock.New()
+			unlock := k.Lock("key")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := k.LockContext(ctx, "key")
+			Expect(err).To(HaveOccurred())
+			Expect(k.Len()).To(Equal(1))
+
+			unlock()
+			Expect(k.Len()).To(BeZero())
+			k.Lock("key")()
+		})
+	})
+})
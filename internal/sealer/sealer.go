@@ -0,0 +1,75 @@
+package sealer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Prefix marks a string produced by Seal, so that sealed and plain values can
+// live side by side in the same document.
+const Prefix = "sealed:v1:"
+
+var ErrNotSealed = errors.New("value is not sealed")
+
+// Sealer encrypts small values with AES-256-GCM. The key supplied by the
+// operator can be any length; it is stretched to 32 bytes with SHA-256.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+func New(key []byte) (*Sealer, error) {
+	if len(key) == 0 {
+		return nil, errors.New("encryption key must not be empty")
+	}
+
+	derived := sha256.Sum256(key)
+	block, err := aes.NewCipher(derived[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{aead: aead}, nil
+}
+
+func (s *Sealer) Seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, plaintext, nil)
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Sealer) Open(value string) ([]byte, error) {
+	if !IsSealed(value) {
+		return nil, ErrNotSealed
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed value is too short")
+	}
+
+	return s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
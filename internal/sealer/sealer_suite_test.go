@@ -0,0 +1,13 @@
+package sealer_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestSealer(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sealer Suite")
+}
@@ -0,0 +1,56 @@
+package sealer_test
+
+import (
+	"code.cloudfoundry.org/volumedriver/internal/sealer"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sealer", func() {
+	var s *sealer.Sealer
+
+	BeforeEach(func() {
+		var err error
+		s, err = sealer.New([]byte("some-key"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("round-trips a value", func() {
+		sealed, err := s.Seal([]byte("secret"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sealed).To(HavePrefix(sealer.Prefix))
+		Expect(sealed).NotTo(ContainSubstring("secret"))
+		Expect(sealer.IsSealed(sealed)).To(BeTrue())
+
+		Expect(s.Open(sealed)).To(Equal([]byte("secret")))
+	})
+
+	It("uses a fresh nonce for every value", func() {
+		first, err := s.Seal([]byte("secret"))
+		Expect(err).NotTo(HaveOccurred())
+		second, err := s.Seal([]byte("secret"))
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(first).NotTo(Equal(second))
+	})
+
+	It("refuses to open a value sealed with a different key", func() {
+		other, err := sealer.New([]byte("other-key"))
+		Expect(err).NotTo(HaveOccurred())
+		sealed, err := other.Seal([]byte("secret"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = s.Open(sealed)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("refuses to open a value that is not sealed", func() {
+		_, err := s.Open("plain")
+		Expect(err).To(MatchError(sealer.ErrNotSealed))
+	})
+
+	It("requires a key", func() {
+		_, err := sealer.New(nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
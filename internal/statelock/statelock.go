@@ -0,0 +1,81 @@
+// Package statelock keeps two driver processes from working on the same
+// state directory at once.
+package statelock
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HeldError is returned by Acquire when another process holds the lock.
+type HeldError struct {
+	Path string
+	// PID of the holder, or 0 if it could not be read from the lock file.
+	PID int
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by another process (pid %d)", e.Path, e.PID)
+}
+
+// Lock is an exclusive advisory lock on a file. The lock file records the
+// PID of its holder and is left in place when the lock is released.
+type Lock struct {
+	path string
+	file *os.File
+}
+
+// Acquire takes the lock on path without waiting, creating the file with mode
+// if needed. Its directory must already exist. It returns a *HeldError if
+// another process has it.
+func Acquire(path string, mode os.FileMode) (*Lock, error) {
+	f, err := lockFile(path, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		_ = unlockFile(f)
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		_ = unlockFile(f)
+		return nil, err
+	}
+
+	return &Lock{path: path, file: f}, nil
+}
+
+// Path returns the lock file.
+func (l *Lock) Path() string {
+	return l.path
+}
+
+// Release gives the lock up. Releasing a lock more than once is harmless.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	l.file = nil
+	return err
+}
+
+func holder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
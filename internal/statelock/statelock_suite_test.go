@@ -0,0 +1,13 @@
+package statelock_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestStatelock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Statelock Suite")
+}
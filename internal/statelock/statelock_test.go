@@ -0,0 +1,55 @@
+package statelock_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"code.cloudfoundry.org/volumedriver/internal/statelock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "driver.lock")
+	})
+
+	It("creates the lock file and records the holder's pid in it", func() {
+		lock, err := statelock.Acquire(path, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		defer lock.Release()
+
+		Expect(os.ReadFile(path)).To(BeEquivalentTo(strconv.Itoa(os.Getpid()) + "\n"))
+	})
+
+	It("leaves creating its directory to the caller", func() {
+		_, err := statelock.Acquire(filepath.Join(filepath.Dir(path), "missing", "driver.lock"), 0600)
+		Expect(err).To(MatchError(os.ErrNotExist))
+	})
+
+	It("cannot be acquired twice", func() {
+		lock, err := statelock.Acquire(path, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		defer lock.Release()
+
+		_, err = statelock.Acquire(path, 0600)
+		var held *statelock.HeldError
+		Expect(err).To(BeAssignableToTypeOf(held))
+		Expect(err.(*statelock.HeldError).PID).To(Equal(os.Getpid()))
+		Expect(err).To(MatchError(path + " is locked by another process (pid " + strconv.Itoa(os.Getpid()) + ")"))
+	})
+
+	It("can be acquired again once released", func() {
+		lock, err := statelock.Acquire(path, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+		Expect(lock.Release()).To(Succeed())
+
+		lock, err = statelock.Acquire(path, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Release()).To(Succeed())
+	})
+})
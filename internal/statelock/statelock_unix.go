@@ -0,0 +1,32 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package statelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func lockFile(path string, mode os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &HeldError{Path: path, PID: holder(path)}
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func unlockFile(f *os.File) error {
+	// Closing the descriptor drops the flock along with it.
+	return f.Close()
+}
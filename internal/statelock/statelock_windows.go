@@ -0,0 +1,44 @@
+//go:build windows
+// +build windows
+
+package statelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// Windows has no flock; opening the file without sharing write access gives
+// the same exclusivity while still letting others read the holder's PID. The
+// mode is ignored, as it is by os.OpenFile on Windows.
+func lockFile(path string, _ os.FileMode) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := syscall.CreateFile(
+		name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ,
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, &HeldError{Path: path, PID: holder(path)}
+		}
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
@@ -71,3 +71,28 @@ func (s *SyncMap[A]) Values() []A {
 	}
 	return result
 }
+
+// Count returns how many values match, and how many values there are, without
+// copying them.
+func (s *SyncMap[A]) Count(match func(A) bool) (matched, total int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, value := range s.data {
+		if match(value) {
+			matched++
+		}
+	}
+	return matched, len(s.data)
+}
+
+func (s *SyncMap[A]) Copy() map[string]A {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	result := make(map[string]A, len(s.data))
+	for key, value := range s.data {
+		result[key] = value
+	}
+	return result
+}
@@ -47,6 +47,17 @@ var _ = Describe("SyncMap", func() {
 		Expect(ok2).To(BeFalse())
 	})
 
+	It("can count the values that match", func() {
+		s := syncmap.New[int]()
+		s.Put("one", 1)
+		s.Put("two", 2)
+		s.Put("three", 3)
+
+		matched, total := s.Count(func(v int) bool { return v > 1 })
+		Expect(matched).To(Equal(2))
+		Expect(total).To(Equal(3))
+	})
+
 	It("can delete a value", func() {
 		const key = "exists"
 		s := syncmap.New[int]()
@@ -91,4 +102,16 @@ var _ = Describe("SyncMap", func() {
 
 		Expect(s.Values()).To(ConsistOf("bar", "fuz", "quz"))
 	})
+
+	It("can return a copy of its contents", func() {
+		s := syncmap.New[int]()
+		s.Put("foo", 1)
+		s.Put("bar", 2)
+
+		c := s.Copy()
+		Expect(c).To(Equal(map[string]int{"foo": 1, "bar": 2}))
+
+		c["baz"] = 3
+		Expect(s.Keys()).To(ConsistOf("foo", "bar"))
+	})
 })
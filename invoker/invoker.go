@@ -9,6 +9,7 @@ import (
 
 	"code.cloudfoundry.org/dockerdriver"
 	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/redactor"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
@@ -32,10 +33,13 @@ type invokeResult struct {
 	errorBuffer  *Buffer
 	logger       lager.Logger
 	invokeErr    error
+	redactor     *redactor.Redactor
 }
 
+// StdError returns the captured stderr with secrets masked, since mount
+// helpers may echo back the options they were given.
 func (i invokeResult) StdError() string {
-	return i.errorBuffer.String()
+	return i.redactor.String(i.errorBuffer.String())
 }
 
 func (i invokeResult) StdOutput() string {
@@ -8,17 +8,25 @@ import (
 
 	"code.cloudfoundry.org/dockerdriver"
 	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/redactor"
 )
 
 type pgroupInvoker struct {
+	redactor *redactor.Redactor
 }
 
+// NewProcessGroupInvoker returns an Invoker that masks secrets in the logged
+// command line and captured stderr using redactor.Default().
 func NewProcessGroupInvoker() Invoker {
-	return &pgroupInvoker{}
+	return NewProcessGroupInvokerWithRedactor(redactor.Default())
+}
+
+func NewProcessGroupInvokerWithRedactor(r *redactor.Redactor) Invoker {
+	return &pgroupInvoker{redactor: r}
 }
 
 func (r *pgroupInvoker) Invoke(env dockerdriver.Env, executable string, cmdArgs []string, envVars ...string) InvokeResult {
-	logger := env.Logger().Session("invoking-command-pgroup", lager.Data{"executable": executable, "args": cmdArgs})
+	logger := env.Logger().Session("invoking-command-pgroup", lager.Data{"executable": executable, "args": r.redactor.Args(cmdArgs)})
 	logger.Info("start")
 	defer logger.Info("end")
 
@@ -37,12 +45,13 @@ func (r *pgroupInvoker) Invoke(env dockerdriver.Env, executable string, cmdArgs
 	err := cmdHandle.Start()
 
 	if err != nil {
-		logger.Error("command-start-failed", err, lager.Data{"exe": executable, "output": stdOutBuffer.String()})
+		logger.Error("command-start-failed", r.redactor.Error(err), lager.Data{"exe": executable, "output": r.redactor.String(stdOutBuffer.String())})
 		return invokeResult{
 			invokeErr:    err,
 			outputBuffer: &stdOutBuffer,
 			errorBuffer:  &stdErrBuffer,
 			logger:       logger,
+			redactor:     r.redactor,
 		}
 	}
 	var cmdDone = false
@@ -69,5 +78,7 @@ func (r *pgroupInvoker) Invoke(env dockerdriver.Env, executable string, cmdArgs
 		cmd:          cmdHandle,
 		outputBuffer: &stdOutBuffer,
 		errorBuffer:  &stdErrBuffer,
-		logger:       logger}
+		logger:       logger,
+		redactor:     r.redactor,
+	}
 }
@@ -15,6 +15,7 @@ import (
 	"code.cloudfoundry.org/dockerdriver/driverhttp"
 	"code.cloudfoundry.org/lager/v3/lagertest"
 	"code.cloudfoundry.org/volumedriver/invoker"
+	"code.cloudfoundry.org/volumedriver/redactor"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
@@ -186,6 +187,39 @@ sleep 7777`}
 			})
 		})
 
+		Context("command is given secrets", func() {
+			BeforeEach(func() {
+				execToInvoke = "bash"
+				argsToExecToInvoke = []string{"-c", "echo mount error: bad option username=someone,password=hunter2 >&2; exit 32", "--", "-o", "username=someone,password=hunter2", "//someone:hunter2@server/share"}
+			})
+
+			It("masks them in the logged command line", func() {
+				Expect(result.Wait()).To(HaveOccurred())
+				Expect(testlogger.Buffer()).To(gbytes.Say(`username=\[REDACTED\],password=\[REDACTED\]`))
+				Expect(string(testlogger.Buffer().Contents())).NotTo(ContainSubstring("hunter2"))
+				Expect(string(testlogger.Buffer().Contents())).NotTo(ContainSubstring("someone"))
+			})
+
+			It("masks them in the captured stderr", func() {
+				Expect(result.Wait()).To(HaveOccurred())
+				Expect(result.StdError()).To(Equal("mount error: bad option username=[REDACTED],password=[REDACTED]\n"))
+			})
+
+			Context("with a custom redactor", func() {
+				BeforeEach(func() {
+					r, err := redactor.New([]string{"token"}, nil)
+					Expect(err).NotTo(HaveOccurred())
+					pgroupInvoker = invoker.NewProcessGroupInvokerWithRedactor(r)
+					argsToExecToInvoke = []string{"-c", "exit 0", "--", "token=abc123"}
+				})
+
+				It("uses its keys", func() {
+					Expect(result.Wait()).To(Succeed())
+					Expect(string(testlogger.Buffer().Contents())).NotTo(ContainSubstring("abc123"))
+				})
+			})
+		})
+
 		Context("unable to start a command", func() {
 			BeforeEach(func() {
 				execToInvoke = "/non-existent-command-that-def-doesnt-exist"
@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContentType is the media type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the latency histograms.
+// They stretch further than Prometheus' defaults because NFS and SMB mounts
+// against a slow server routinely take tens of seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// PrometheusRecorder keeps measurements in memory and serves them in the
+// Prometheus text exposition format. It is an http.Handler, so it can be
+// mounted directly on a /metrics endpoint.
+type PrometheusRecorder struct {
+	namespace string
+	buckets   []float64
+
+	lock            sync.Mutex
+	operations      map[labels]*histogram
+	drains          map[labels]*histogram
+	mountAttempts   map[labels]uint64
+	remounts        map[labels]uint64
+	volumes         int
+	activeMounts    int
+	persistFailures uint64
+	failedUnmounts  uint64
+}
+
+// NewPrometheusRecorder returns a recorder whose metric names are prefixed
+// with namespace, for example "nfsdriver". Buckets default to DefaultBuckets.
+func NewPrometheusRecorder(namespace string, buckets ...float64) *PrometheusRecorder {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &PrometheusRecorder{
+		namespace:     namespace,
+		buckets:       buckets,
+		operations:    map[labels]*histogram{},
+		drains:        map[labels]*histogram{},
+		mountAttempts: map[labels]uint64{},
+		remounts:      map[labels]uint64{},
+	}
+}
+
+func (r *PrometheusRecorder) ObserveOperation(operation string, outcome Outcome, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.observe(r.operations, labels{"operation", operation, "outcome", string(outcome)}, duration)
+}
+
+func (r *PrometheusRecorder) SetVolumes(count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.volumes = count
+}
+
+func (r *PrometheusRecorder) SetActiveMounts(count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.activeMounts = count
+}
+
+func (r *PrometheusRecorder) IncPersistFailures() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.persistFailures++
+}
+
+func (r *PrometheusRecorder) ObserveMountAttempt(outcome Outcome) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.mountAttempts[labels{"outcome", string(outcome)}]++
+}
+
+func (r *PrometheusRecorder) ObserveRemount(reason RemountReason, outcome Outcome) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.remounts[labels{"reason", string(reason), "outcome", string(outcome)}]++
+}
+
+func (r *PrometheusRecorder) ObserveDrain(outcome Outcome, duration time.Duration, failedUnmounts int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.observe(r.drains, labels{"outcome", string(outcome)}, duration)
+	r.failedUnmounts += uint64(failedUnmounts)
+}
+
+func (r *PrometheusRecorder) observe(histograms map[labels]*histogram, l labels, duration time.Duration) {
+	h, ok := histograms[l]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		histograms[l] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range r.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes the current measurements in the text exposition format.
+func (r *PrometheusRecorder) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_ = r.Write(w)
+}
+
+// Write writes the current measurements in the text exposition format.
+func (r *PrometheusRecorder) Write(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b := bufio.NewWriter(w)
+
+	r.writeHistograms(b, "operation_duration_seconds", "Duration of volume driver operations.", r.operations)
+	r.writeCounters(b, "operations_total", "Volume driver operations, by outcome.", histogramCounts(r.operations))
+	r.writeGauge(b, "volumes", "Volumes known to the driver.", r.volumes)
+	r.writeGauge(b, "active_mounts", "Volumes with at least one active mount.", r.activeMounts)
+	r.writeCounters(b, "persist_state_failures_total", "Failed writes of the driver state file.", map[labels]uint64{{}: r.persistFailures})
+	r.writeCounters(b, "mount_attempts_total", "Calls to the mounter, including retries, by outcome.", r.mountAttempts)
+	r.writeCounters(b, "remounts_total", "Remounts of stale volumes, by reason and outcome.", r.remounts)
+	r.writeHistograms(b, "drain_duration_seconds", "Duration of driver drains.", r.drains)
+	r.writeCounters(b, "drains_total", "Driver drains, by outcome.", histogramCounts(r.drains))
+	r.writeCounters(b, "drain_failed_unmounts_total", "Unmounts that failed while draining.", map[labels]uint64{{}: r.failedUnmounts})
+
+	return b.Flush()
+}
+
+func (r *PrometheusRecorder) name(name string) string {
+	if r.namespace == "" {
+		return name
+	}
+	return r.namespace + "_" + name
+}
+
+func (r *PrometheusRecorder) writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (r *PrometheusRecorder) writeGauge(w io.Writer, name, help string, value int) {
+	name = r.name(name)
+	r.writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func (r *PrometheusRecorder) writeCounters(w io.Writer, name, help string, values map[labels]uint64) {
+	name = r.name(name)
+	r.writeHeader(w, name, help, "counter")
+	for _, l := range sortedLabels(values) {
+		fmt.Fprintf(w, "%s%s %d\n", name, l, values[l])
+	}
+}
+
+func (r *PrometheusRecorder) writeHistograms(w io.Writer, name, help string, histograms map[labels]*histogram) {
+	name = r.name(name)
+	r.writeHeader(w, name, help, "histogram")
+	for _, l := range sortedLabels(histograms) {
+		h := histograms[l]
+		for i, bound := range r.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.with("le", formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, l.with("le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, l, formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, l, h.count)
+	}
+}
+
+type histogram struct {
+	counts []uint64 // cumulative, one per bucket
+	count  uint64
+	sum    float64
+}
+
+func histogramCounts(histograms map[labels]*histogram) map[labels]uint64 {
+	counts := map[labels]uint64{}
+	for l, h := range histograms {
+		counts[l] = h.count
+	}
+	return counts
+}
+
+// labels holds up to two name/value pairs, which is all this package needs,
+// in a form that can be used as a map key.
+type labels [4]string
+
+func (l labels) with(name, value string) string {
+	pairs := l.pairs()
+	pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (l labels) pairs() []string {
+	var pairs []string
+	for i := 0; i < len(l); i += 2 {
+		if l[i] != "" {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", l[i], l[i+1]))
+		}
+	}
+	return pairs
+}
+
+func (l labels) String() string {
+	pairs := l.pairs()
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedLabels[V any](m map[labels]V) []labels {
+	keys := make([]labels, 0, len(m))
+	for l := range m {
+		keys = append(keys, l)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
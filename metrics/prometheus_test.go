@@ -0,0 +1,104 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/volumedriver/metrics"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrometheusRecorder", func() {
+	var (
+		recorder *metrics.PrometheusRecorder
+		output   string
+	)
+
+	BeforeEach(func() {
+		recorder = metrics.NewPrometheusRecorder("volumedriver", 0.1, 1)
+	})
+
+	JustBeforeEach(func() {
+		buffer := &bytes.Buffer{}
+		Expect(recorder.Write(buffer)).To(Succeed())
+		output = buffer.String()
+	})
+
+	Context("when nothing has been recorded", func() {
+		It("writes zero-valued gauges and counters", func() {
+			Expect(output).To(ContainSubstring("# TYPE volumedriver_volumes gauge\nvolumedriver_volumes 0\n"))
+			Expect(output).To(ContainSubstring("# TYPE volumedriver_persist_state_failures_total counter\nvolumedriver_persist_state_failures_total 0\n"))
+			Expect(output).To(ContainSubstring("# TYPE volumedriver_operation_duration_seconds histogram\n"))
+		})
+	})
+
+	Context("when operations have been observed", func() {
+		BeforeEach(func() {
+			recorder.ObserveOperation("mount", metrics.OutcomeSuccess, 50*time.Millisecond)
+			recorder.ObserveOperation("mount", metrics.OutcomeSuccess, 500*time.Millisecond)
+			recorder.ObserveOperation("mount", metrics.OutcomeFailure, 5*time.Second)
+		})
+
+		It("writes a cumulative latency histogram per operation and outcome", func() {
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_bucket{operation="mount",outcome="success",le="0.1"} 1` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_bucket{operation="mount",outcome="success",le="1"} 2` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_bucket{operation="mount",outcome="success",le="+Inf"} 2` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_sum{operation="mount",outcome="success"} 0.55` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_bucket{operation="mount",outcome="failure",le="1"} 0` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operation_duration_seconds_count{operation="mount",outcome="failure"} 1` + "\n"))
+		})
+
+		It("writes a counter per operation and outcome", func() {
+			Expect(output).To(ContainSubstring(`volumedriver_operations_total{operation="mount",outcome="failure"} 1` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_operations_total{operation="mount",outcome="success"} 2` + "\n"))
+		})
+	})
+
+	Context("when gauges and counters have been recorded", func() {
+		BeforeEach(func() {
+			recorder.SetVolumes(3)
+			recorder.SetActiveMounts(2)
+			recorder.IncPersistFailures()
+			recorder.ObserveRemount(metrics.RemountOnHealthCheck, metrics.OutcomeSuccess)
+			recorder.ObserveMountAttempt(metrics.OutcomeFailure)
+			recorder.ObserveDrain(metrics.OutcomeFailure, 2*time.Second, 2)
+		})
+
+		It("writes their latest values", func() {
+			Expect(output).To(ContainSubstring("volumedriver_volumes 3\n"))
+			Expect(output).To(ContainSubstring("volumedriver_active_mounts 2\n"))
+			Expect(output).To(ContainSubstring("volumedriver_persist_state_failures_total 1\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_mount_attempts_total{outcome="failure"} 1` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_remounts_total{reason="health-monitor",outcome="success"} 1` + "\n"))
+			Expect(output).To(ContainSubstring(`volumedriver_drains_total{outcome="failure"} 1` + "\n"))
+			Expect(output).To(ContainSubstring("volumedriver_drain_failed_unmounts_total 2\n"))
+		})
+	})
+
+	Context("when served over HTTP", func() {
+		It("responds with the text exposition format", func() {
+			recorder.SetVolumes(1)
+
+			response := httptest.NewRecorder()
+			recorder.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Header().Get("Content-Type")).To(Equal(metrics.ContentType))
+			Expect(response.Body.String()).To(ContainSubstring("volumedriver_volumes 1\n"))
+		})
+	})
+
+	Context("without a namespace", func() {
+		BeforeEach(func() {
+			recorder = metrics.NewPrometheusRecorder("")
+		})
+
+		It("does not prefix metric names", func() {
+			Expect(output).To(ContainSubstring("\nvolumes 0\n"))
+			Expect(output).To(ContainSubstring(`# TYPE operation_duration_seconds histogram`))
+		})
+	})
+})
@@ -0,0 +1,61 @@
+// Package metrics records what a VolumeDriver is doing so that it can be
+// scraped by Prometheus or any other monitoring system.
+package metrics
+
+import "time"
+
+// Outcome labels the result of an observed operation.
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// RemountReason labels what caused a volume to be remounted.
+type RemountReason string
+
+const (
+	// RemountOnMount is a remount of a stale volume by a later Mount call.
+	RemountOnMount RemountReason = "mount"
+	// RemountOnReconcile is a remount of a restored volume at startup.
+	RemountOnReconcile RemountReason = "reconcile"
+	// RemountOnHealthCheck is a remount by the background health monitor.
+	RemountOnHealthCheck RemountReason = "health-monitor"
+)
+
+//go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
+//counterfeiter:generate -o ../metricsfakes/fake_recorder.go . Recorder
+
+// Recorder receives measurements from a VolumeDriver. Implementations must be
+// safe for concurrent use.
+type Recorder interface {
+	// ObserveOperation records a call to one of the dockerdriver.Driver methods.
+	ObserveOperation(operation string, outcome Outcome, duration time.Duration)
+	// SetVolumes records the number of volumes known to the driver.
+	SetVolumes(count int)
+	// SetActiveMounts records the number of volumes with a live mount.
+	SetActiveMounts(count int)
+	// IncPersistFailures records a failed write of the state file.
+	IncPersistFailures()
+	// ObserveMountAttempt records a single call to the Mounter, including
+	// each retry of a failed mount.
+	ObserveMountAttempt(outcome Outcome)
+	// ObserveRemount records an attempt to remount a stale volume.
+	ObserveRemount(reason RemountReason, outcome Outcome)
+	// ObserveDrain records a call to Drain and how many unmounts it failed.
+	ObserveDrain(outcome Outcome, duration time.Duration, failedUnmounts int)
+}
+
+// Discard is a Recorder that drops every measurement.
+var Discard Recorder = discard{}
+
+type discard struct{}
+
+func (discard) ObserveOperation(string, Outcome, time.Duration) {}
+func (discard) SetVolumes(int)                                  {}
+func (discard) SetActiveMounts(int)                             {}
+func (discard) IncPersistFailures()                             {}
+func (discard) ObserveMountAttempt(Outcome)                     {}
+func (discard) ObserveRemount(RemountReason, Outcome)           {}
+func (discard) ObserveDrain(Outcome, time.Duration, int)        {}
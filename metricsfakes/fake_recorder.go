@@ -0,0 +1,312 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package metricsfakes
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/volumedriver/metrics"
+)
+
+type FakeRecorder struct {
+	IncPersistFailuresStub        func()
+	incPersistFailuresMutex       sync.RWMutex
+	incPersistFailuresArgsForCall []struct {
+	}
+	ObserveDrainStub        func(metrics.Outcome, time.Duration, int)
+	observeDrainMutex       sync.RWMutex
+	observeDrainArgsForCall []struct {
+		arg1 metrics.Outcome
+		arg2 time.Duration
+		arg3 int
+	}
+	ObserveMountAttemptStub        func(metrics.Outcome)
+	observeMountAttemptMutex       sync.RWMutex
+	observeMountAttemptArgsForCall []struct {
+		arg1 metrics.Outcome
+	}
+	ObserveOperationStub        func(string, metrics.Outcome, time.Duration)
+	observeOperationMutex       sync.RWMutex
+	observeOperationArgsForCall []struct {
+		arg1 string
+		arg2 metrics.Outcome
+		arg3 time.Duration
+	}
+	ObserveRemountStub        func(metrics.RemountReason, metrics.Outcome)
+	observeRemountMutex       sync.RWMutex
+	observeRemountArgsForCall []struct {
+		arg1 metrics.RemountReason
+		arg2 metrics.Outcome
+	}
+	SetActiveMountsStub        func(int)
+	setActiveMountsMutex       sync.RWMutex
+	setActiveMountsArgsForCall []struct {
+		arg1 int
+	}
+	SetVolumesStub        func(int)
+	setVolumesMutex       sync.RWMutex
+	setVolumesArgsForCall []struct {
+		arg1 int
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeRecorder) IncPersistFailures() {
+	fake.incPersistFailuresMutex.Lock()
+	fake.incPersistFailuresArgsForCall = append(fake.incPersistFailuresArgsForCall, struct {
+	}{})
+	stub := fake.IncPersistFailuresStub
+	fake.recordInvocation("IncPersistFailures", []interface{}{})
+	fake.incPersistFailuresMutex.Unlock()
+	if stub != nil {
+		fake.IncPersistFailuresStub()
+	}
+}
+
+func (fake *FakeRecorder) IncPersistFailuresCallCount() int {
+	fake.incPersistFailuresMutex.RLock()
+	defer fake.incPersistFailuresMutex.RUnlock()
+	return len(fake.incPersistFailuresArgsForCall)
+}
+
+func (fake *FakeRecorder) IncPersistFailuresCalls(stub func()) {
+	fake.incPersistFailuresMutex.Lock()
+	defer fake.incPersistFailuresMutex.Unlock()
+	fake.IncPersistFailuresStub = stub
+}
+
+func (fake *FakeRecorder) ObserveDrain(arg1 metrics.Outcome, arg2 time.Duration, arg3 int) {
+	fake.observeDrainMutex.Lock()
+	fake.observeDrainArgsForCall = append(fake.observeDrainArgsForCall, struct {
+		arg1 metrics.Outcome
+		arg2 time.Duration
+		arg3 int
+	}{arg1, arg2, arg3})
+	stub := fake.ObserveDrainStub
+	fake.recordInvocation("ObserveDrain", []interface{}{arg1, arg2, arg3})
+	fake.observeDrainMutex.Unlock()
+	if stub != nil {
+		fake.ObserveDrainStub(arg1, arg2, arg3)
+	}
+}
+
+func (fake *FakeRecorder) ObserveDrainCallCount() int {
+	fake.observeDrainMutex.RLock()
+	defer fake.observeDrainMutex.RUnlock()
+	return len(fake.observeDrainArgsForCall)
+}
+
+func (fake *FakeRecorder) ObserveDrainCalls(stub func(metrics.Outcome, time.Duration, int)) {
+	fake.observeDrainMutex.Lock()
+	defer fake.observeDrainMutex.Unlock()
+	fake.ObserveDrainStub = stub
+}
+
+func (fake *FakeRecorder) ObserveDrainArgsForCall(i int) (metrics.Outcome, time.Duration, int) {
+	fake.observeDrainMutex.RLock()
+	defer fake.observeDrainMutex.RUnlock()
+	argsForCall := fake.observeDrainArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeRecorder) ObserveMountAttempt(arg1 metrics.Outcome) {
+	fake.observeMountAttemptMutex.Lock()
+	fake.observeMountAttemptArgsForCall = append(fake.observeMountAttemptArgsForCall, struct {
+		arg1 metrics.Outcome
+	}{arg1})
+	stub := fake.ObserveMountAttemptStub
+	fake.recordInvocation("ObserveMountAttempt", []interface{}{arg1})
+	fake.observeMountAttemptMutex.Unlock()
+	if stub != nil {
+		fake.ObserveMountAttemptStub(arg1)
+	}
+}
+
+func (fake *FakeRecorder) ObserveMountAttemptCallCount() int {
+	fake.observeMountAttemptMutex.RLock()
+	defer fake.observeMountAttemptMutex.RUnlock()
+	return len(fake.observeMountAttemptArgsForCall)
+}
+
+func (fake *FakeRecorder) ObserveMountAttemptCalls(stub func(metrics.Outcome)) {
+	fake.observeMountAttemptMutex.Lock()
+	defer fake.observeMountAttemptMutex.Unlock()
+	fake.ObserveMountAttemptStub = stub
+}
+
+func (fake *FakeRecorder) ObserveMountAttemptArgsForCall(i int) metrics.Outcome {
+	fake.observeMountAttemptMutex.RLock()
+	defer fake.observeMountAttemptMutex.RUnlock()
+	argsForCall := fake.observeMountAttemptArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeRecorder) ObserveOperation(arg1 string, arg2 metrics.Outcome, arg3 time.Duration) {
+	fake.observeOperationMutex.Lock()
+	fake.observeOperationArgsForCall = append(fake.observeOperationArgsForCall, struct {
+		arg1 string
+		arg2 metrics.Outcome
+		arg3 time.Duration
+	}{arg1, arg2, arg3})
+	stub := fake.ObserveOperationStub
+	fake.recordInvocation("ObserveOperation", []interface{}{arg1, arg2, arg3})
+	fake.observeOperationMutex.Unlock()
+	if stub != nil {
+		fake.ObserveOperationStub(arg1, arg2, arg3)
+	}
+}
+
+func (fake *FakeRecorder) ObserveOperationCallCount() int {
+	fake.observeOperationMutex.RLock()
+	defer fake.observeOperationMutex.RUnlock()
+	return len(fake.observeOperationArgsForCall)
+}
+
+func (fake *FakeRecorder) ObserveOperationCalls(stub func(string, metrics.Outcome, time.Duration)) {
+	fake.observeOperationMutex.Lock()
+	defer fake.observeOperationMutex.Unlock()
+	fake.ObserveOperationStub = stub
+}
+
+func (fake *FakeRecorder) ObserveOperationArgsForCall(i int) (string, metrics.Outcome, time.Duration) {
+	fake.observeOperationMutex.RLock()
+	defer fake.observeOperationMutex.RUnlock()
+	argsForCall := fake.observeOperationArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeRecorder) ObserveRemount(arg1 metrics.RemountReason, arg2 metrics.Outcome) {
+	fake.observeRemountMutex.Lock()
+	fake.observeRemountArgsForCall = append(fake.observeRemountArgsForCall, struct {
+		arg1 metrics.RemountReason
+		arg2 metrics.Outcome
+	}{arg1, arg2})
+	stub := fake.ObserveRemountStub
+	fake.recordInvocation("ObserveRemount", []interface{}{arg1, arg2})
+	fake.observeRemountMutex.Unlock()
+	if stub != nil {
+		fake.ObserveRemountStub(arg1, arg2)
+	}
+}
+
+func (fake *FakeRecorder) ObserveRemountCallCount() int {
+	fake.observeRemountMutex.RLock()
+	defer fake.observeRemountMutex.RUnlock()
+	return len(fake.observeRemountArgsForCall)
+}
+
+func (fake *FakeRecorder) ObserveRemountCalls(stub func(metrics.RemountReason, metrics.Outcome)) {
+	fake.observeRemountMutex.Lock()
+	defer fake.observeRemountMutex.Unlock()
+	fake.ObserveRemountStub = stub
+}
+
+func (fake *FakeRecorder) ObserveRemountArgsForCall(i int) (metrics.RemountReason, metrics.Outcome) {
+	fake.observeRemountMutex.RLock()
+	defer fake.observeRemountMutex.RUnlock()
+	argsForCall := fake.observeRemountArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeRecorder) SetActiveMounts(arg1 int) {
+	fake.setActiveMountsMutex.Lock()
+	fake.setActiveMountsArgsForCall = append(fake.setActiveMountsArgsForCall, struct {
+		arg1 int
+	}{arg1})
+	stub := fake.SetActiveMountsStub
+	fake.recordInvocation("SetActiveMounts", []interface{}{arg1})
+	fake.setActiveMountsMutex.Unlock()
+	if stub != nil {
+		fake.SetActiveMountsStub(arg1)
+	}
+}
+
+func (fake *FakeRecorder) SetActiveMountsCallCount() int {
+	fake.setActiveMountsMutex.RLock()
+	defer fake.setActiveMountsMutex.RUnlock()
+	return len(fake.setActiveMountsArgsForCall)
+}
+
+func (fake *FakeRecorder) SetActiveMountsCalls(stub func(int)) {
+	fake.setActiveMountsMutex.Lock()
+	defer fake.setActiveMountsMutex.Unlock()
+	fake.SetActiveMountsStub = stub
+}
+
+func (fake *FakeRecorder) SetActiveMountsArgsForCall(i int) int {
+	fake.setActiveMountsMutex.RLock()
+	defer fake.setActiveMountsMutex.RUnlock()
+	argsForCall := fake.setActiveMountsArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeRecorder) SetVolumes(arg1 int) {
+	fake.setVolumesMutex.Lock()
+	fake.setVolumesArgsForCall = append(fake.setVolumesArgsForCall, struct {
+		arg1 int
+	}{arg1})
+	stub := fake.SetVolumesStub
+	fake.recordInvocation("SetVolumes", []interface{}{arg1})
+	fake.setVolumesMutex.Unlock()
+	if stub != nil {
+		fake.SetVolumesStub(arg1)
+	}
+}
+
+func (fake *FakeRecorder) SetVolumesCallCount() int {
+	fake.setVolumesMutex.RLock()
+	defer fake.setVolumesMutex.RUnlock()
+	return len(fake.setVolumesArgsForCall)
+}
+
+func (fake *FakeRecorder) SetVolumesCalls(stub func(int)) {
+	fake.setVolumesMutex.Lock()
+	defer fake.setVolumesMutex.Unlock()
+	fake.SetVolumesStub = stub
+}
+
+func (fake *FakeRecorder) SetVolumesArgsForCall(i int) int {
+	fake.setVolumesMutex.RLock()
+	defer fake.setVolumesMutex.RUnlock()
+	argsForCall := fake.setVolumesArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *FakeRecorder) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.incPersistFailuresMutex.RLock()
+	defer fake.incPersistFailuresMutex.RUnlock()
+	fake.observeDrainMutex.RLock()
+	defer fake.observeDrainMutex.RUnlock()
+	fake.observeMountAttemptMutex.RLock()
+	defer fake.observeMountAttemptMutex.RUnlock()
+	fake.observeOperationMutex.RLock()
+	defer fake.observeOperationMutex.RUnlock()
+	fake.observeRemountMutex.RLock()
+	defer fake.observeRemountMutex.RUnlock()
+	fake.setActiveMountsMutex.RLock()
+	defer fake.setActiveMountsMutex.RUnlock()
+	fake.setVolumesMutex.RLock()
+	defer fake.setVolumesMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeRecorder) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ metrics.Recorder = new(FakeRecorder)
@@ -0,0 +1,94 @@
+package volumedriver
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// mountFlight collapses concurrent Mount requests for a volume into one. The
+// first request leads: it makes the kernel mount or checks the existing one,
+// then commits a reference for every request that joined it while it ran.
+// Every request gets the leader's response.
+type mountFlight struct {
+	// callers are the caller IDs of the requests in the flight, with "" for
+	// those that sent none.
+	callers  []string
+	done     chan struct{}
+	response dockerdriver.MountResponse
+}
+
+// mountFlights tracks the mount in flight for each volume.
+type mountFlights struct {
+	lock    sync.Mutex
+	flights map[string]*mountFlight
+}
+
+func newMountFlights() *mountFlights {
+	return &mountFlights{flights: map[string]*mountFlight{}}
+}
+
+// join returns the flight for name, and whether the caller leads it.
+func (m *mountFlights) join(name, callerID string) (*mountFlight, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if f, ok := m.flights[name]; ok {
+		f.callers = append(f.callers, callerID)
+		return f, false
+	}
+	f := &mountFlight{callers: []string{callerID}, done: make(chan struct{})}
+	m.flights[name] = f
+	return f, true
+}
+
+// leave takes a caller that has given up waiting out of the flight. It
+// returns false if the flight has already committed the caller's reference.
+func (m *mountFlights) leave(name, callerID string, f *mountFlight) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.flights[name] != f {
+		return false
+	}
+	for i, id := range f.callers {
+		if id == callerID {
+			f.callers = append(f.callers[:i:i], f.callers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// close stops callers joining the flight, and returns the callers it is
+// committing a reference for. Later callers start a new flight.
+func (m *mountFlights) close(name string, f *mountFlight) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.flights[name] == f {
+		delete(m.flights, name)
+	}
+	return f.callers
+}
+
+// awaitMountFlight waits for the leader of f to respond. A caller whose
+// request ends first leaves the flight, so that no reference is committed
+// for it.
+func (d *VolumeDriver) awaitMountFlight(env dockerdriver.Env, logger lager.Logger, name string, f *mountFlight) dockerdriver.MountResponse {
+	logger.Info("joined-mount-in-flight")
+
+	select {
+	case <-f.done:
+		return f.response
+	case <-env.Context().Done():
+	}
+
+	if !d.mountFlights.leave(name, CallerID(env.Context()), f) {
+		<-f.done
+		return f.response
+	}
+	logger.Info("left-mount-in-flight", lager.Data{"reason": env.Context().Err().Error()})
+	return dockerdriver.MountResponse{Err: d.errorText(d.mountError(env, name, "", env.Context().Err()))}
+}
@@ -0,0 +1,124 @@
+package volumedriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+type callerIDKey struct{}
+
+// WithCallerID returns a context that identifies the caller of Mount or
+// Unmount. The driver holds one reference to a volume per caller ID, so a
+// repeated Mount or Unmount with the same ID changes nothing. Requests
+// without an ID are counted instead.
+func WithCallerID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, callerIDKey{}, id)
+}
+
+// CallerID returns the caller ID in ctx, or "" if there is none.
+func CallerID(ctx context.Context) string {
+	id, _ := ctx.Value(callerIDKey{}).(string)
+	return id
+}
+
+// NewCallerIDHandler passes the ID that the Docker volume plugin protocol
+// sends with Mount and Unmount requests to the driver, which
+// dockerdriver.MountRequest and UnmountRequest have no field for. It wraps
+// the handler from driverhttp.NewHandler.
+func NewCallerIDHandler(next http.Handler) http.Handler {
+	paths := map[string]bool{}
+	for _, name := range []string{dockerdriver.MountRoute, dockerdriver.UnmountRoute} {
+		if route, ok := dockerdriver.Routes.FindRouteByName(name); ok {
+			paths[route.Path] = true
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !paths[r.URL.Path] || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var request struct{ ID string }
+		if json.Unmarshal(body, &request) == nil && request.ID != "" {
+			r = r.WithContext(WithCallerID(r.Context(), request.ID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addReference records a Mount by the caller with the given ID, and reports
+// whether it added a reference. A caller that already holds one does not get
+// another.
+func addReference(volume *NfsVolumeInfo, id string) bool {
+	if id != "" {
+		for _, existing := range volume.MountIDs {
+			if existing == id {
+				return false
+			}
+		}
+		volume.MountIDs = append(append([]string{}, volume.MountIDs...), id)
+	}
+	volume.MountCount++
+	return true
+}
+
+// holdsReference reports whether an Unmount by the caller with the given ID
+// would release a reference. A caller with an ID holds a reference if it
+// mounted the volume with that ID, or if there is a counted reference without
+// an ID, as taken by callers that sent none or before IDs were tracked.
+func holdsReference(volume NfsVolumeInfo, id string) bool {
+	if id == "" {
+		return volume.MountCount > 0
+	}
+	for _, existing := range volume.MountIDs {
+		if existing == id {
+			return true
+		}
+	}
+	return volume.MountCount > len(volume.MountIDs)
+}
+
+// releaseReference removes the reference held by the caller with the given
+// ID. A caller without an ID, or with one it did not mount with, releases a
+// counted reference, or the most recent ID if every reference has one, so
+// that MountCount never drops below the number of IDs.
+func releaseReference(logger lager.Logger, volume *NfsVolumeInfo, id string) {
+	ids := make([]string, 0, len(volume.MountIDs))
+	released := false
+	for _, existing := range volume.MountIDs {
+		if !released && existing == id {
+			released = true
+			continue
+		}
+		ids = append(ids, existing)
+	}
+	volume.MountIDs = ids
+	if id != "" && !released {
+		logger.Info("released-counted-reference-for-unknown-mount-id", lager.Data{"mount-id": id})
+	}
+
+	volume.MountCount--
+	if volume.MountCount < len(volume.MountIDs) {
+		dropped := volume.MountIDs[len(volume.MountIDs)-1]
+		volume.MountIDs = volume.MountIDs[:len(volume.MountIDs)-1]
+		logger.Info("released-mount-id-without-caller-id", lager.Data{"mount-id": dropped})
+	}
+	if len(volume.MountIDs) == 0 {
+		volume.MountIDs = nil
+	}
+}
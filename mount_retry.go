@@ -0,0 +1,222 @@
+package volumedriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/mountclassifier"
+)
+
+// Create opts that override the driver's RetryPolicy for a single volume.
+// They are consumed by the driver and never passed on to the Mounter.
+// Backoffs are Go durations such as "500ms", or a number of seconds.
+const (
+	RetryMaxAttemptsOpt    = "mount_retry_max_attempts"
+	RetryInitialBackoffOpt = "mount_retry_initial_backoff"
+	RetryMaxBackoffOpt     = "mount_retry_max_backoff"
+	RetryJitterOpt         = "mount_retry_jitter"
+)
+
+var retryOpts = []string{RetryMaxAttemptsOpt, RetryInitialBackoffOpt, RetryMaxBackoffOpt, RetryJitterOpt}
+
+// RetryPolicy decides how often a failed mount is attempted again.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt. It doubles
+	// after every further failure up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter spreads each wait by up to this fraction in either direction.
+	Jitter float64
+	// Retryable reports whether a mount error is worth another attempt.
+	// Defaults to DefaultRetryable.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy makes a single attempt, which is how the driver has
+// always behaved.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    1,
+	InitialBackoff: time.Second,
+	MaxBackoff:     10 * time.Second,
+	Jitter:         0.2,
+}
+
+// DefaultRetryable retries every error except a cancelled or expired request,
+// and mount failures classified as something a retry will not fix, such as
+// rejected credentials. See mountclassifier.
+func DefaultRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return mountclassifier.CategoryOf(err).Retryable()
+}
+
+// WithMountRetryPolicy sets the policy used for every mount, unless a volume
+// overrides it through its Create opts. Defaults to DefaultRetryPolicy.
+func WithMountRetryPolicy(policy RetryPolicy) Option {
+	return func(d *VolumeDriver) {
+		d.retryPolicy = policy
+	}
+}
+
+// retryPolicyFor returns the driver's policy with any overrides from opts applied.
+func (d *VolumeDriver) retryPolicyFor(opts map[string]interface{}) (RetryPolicy, error) {
+	policy := d.retryPolicy
+
+	if v, ok := opts[RetryMaxAttemptsOpt]; ok {
+		attempts, err := intOpt(v)
+		if err != nil || attempts < 1 {
+			return policy, fmt.Errorf("invalid '%s': %v", RetryMaxAttemptsOpt, v)
+		}
+		policy.MaxAttempts = attempts
+	}
+	if v, ok := opts[RetryInitialBackoffOpt]; ok {
+		backoff, err := durationOpt(v)
+		if err != nil || backoff < 0 {
+			return policy, fmt.Errorf("invalid '%s': %v", RetryInitialBackoffOpt, v)
+		}
+		policy.InitialBackoff = backoff
+	}
+	if v, ok := opts[RetryMaxBackoffOpt]; ok {
+		backoff, err := durationOpt(v)
+		if err != nil || backoff < 0 {
+			return policy, fmt.Errorf("invalid '%s': %v", RetryMaxBackoffOpt, v)
+		}
+		policy.MaxBackoff = backoff
+	}
+	if v, ok := opts[RetryJitterOpt]; ok {
+		jitter, err := floatOpt(v)
+		if err != nil || jitter < 0 || jitter > 1 {
+			return policy, fmt.Errorf("invalid '%s': %v", RetryJitterOpt, v)
+		}
+		policy.Jitter = jitter
+	}
+
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxBackoff < policy.InitialBackoff {
+		policy.MaxBackoff = policy.InitialBackoff
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = DefaultRetryable
+	}
+	return policy, nil
+}
+
+// mountWithRetry calls the Mounter until it succeeds, the policy gives up, or
+// the next wait would run past the request's deadline. The last mount error
+// is returned unchanged so that callers still see any dockerdriver.SafeError.
+func (d *VolumeDriver) mountWithRetry(env dockerdriver.Env, logger lager.Logger, source, mountPath string, opts map[string]interface{}) error {
+	policy, err := d.retryPolicyFor(opts)
+	if err != nil {
+		logger.Error("invalid-retry-policy", err)
+		policy, _ = d.retryPolicyFor(nil)
+	}
+
+	mounterOpts := copyOpts(opts)
+	for _, key := range retryOpts {
+		delete(mounterOpts, key)
+	}
+	delete(mounterOpts, MountTimeoutOpt)
+
+	ctx := env.Context()
+	for attempt := 1; ; attempt++ {
+		err = d.mounter.Mount(env, source, mountPath, mounterOpts)
+		d.metrics.ObserveMountAttempt(outcomeOf(err))
+		if err == nil {
+			if attempt > 1 {
+				logger.Info("mount-attempt-succeeded", lager.Data{"attempt": attempt})
+			}
+			return nil
+		}
+
+		logger.Error("mount-attempt-failed", d.redactor.Error(err), lager.Data{"attempt": attempt, "max-attempts": policy.MaxAttempts})
+		if attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		// Context deadlines are on the real clock, so the backoff is too.
+		wait := backoff(policy.InitialBackoff, policy.MaxBackoff, policy.Jitter, attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			logger.Info("mount-retry-abandoned", lager.Data{"reason": "deadline", "attempt": attempt})
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("mount-retry-abandoned", lager.Data{"reason": ctx.Err().Error(), "attempt": attempt})
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the wait after the given number of consecutive failures:
+// initial, doubled for every further failure and capped at max, then spread
+// by up to jitter in either direction.
+func backoff(initial, max time.Duration, jitter float64, failures int) time.Duration {
+	wait := initial
+	for i := 1; i < failures && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+
+	if jitter > 0 {
+		spread := float64(wait) * jitter
+		wait += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return wait
+}
+
+func intOpt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("not a whole number: %v", v)
+		}
+		return int(v), nil
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
+
+func floatOpt(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
+
+func durationOpt(v interface{}) (time.Duration, error) {
+	if s, ok := v.(string); ok {
+		if d, err := time.ParseDuration(s); err == nil {
+			return d, nil
+		}
+	}
+	seconds, err := floatOpt(v)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
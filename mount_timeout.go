@@ -0,0 +1,97 @@
+package volumedriver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/dockerdriver/driverhttp"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+)
+
+// MountTimeoutOpt is a Create opt that overrides the driver's mount timeout
+// for a single volume, as a Go duration such as "30s" or a number of
+// seconds. "0" removes the timeout. It is never passed on to the Mounter.
+const MountTimeoutOpt = "mount_timeout"
+
+// DefaultMountDurationWarning is how long a mount may take before the driver
+// logs a warning.
+const DefaultMountDurationWarning = 8 * time.Second
+
+// WithMountTimeout bounds every mount, including its retries, unless a
+// volume overrides it through its Create opts. A mount that overruns fails
+// with a *drivererrors.MountTimeoutError. Defaults to 0, which leaves mounts
+// bounded only by the request's deadline.
+func WithMountTimeout(timeout time.Duration) Option {
+	return func(d *VolumeDriver) {
+		d.mountTimeout = timeout
+	}
+}
+
+// WithMountDurationWarning sets how long a mount may take before the driver
+// logs "mount-duration-too-high". 0 turns the warning off. Defaults to
+// DefaultMountDurationWarning.
+func WithMountDurationWarning(threshold time.Duration) Option {
+	return func(d *VolumeDriver) {
+		d.mountDurationWarning = threshold
+	}
+}
+
+// mountTimeoutFor returns the driver's mount timeout, or the override in opts.
+func (d *VolumeDriver) mountTimeoutFor(opts map[string]interface{}) (time.Duration, error) {
+	v, ok := opts[MountTimeoutOpt]
+	if !ok {
+		return d.mountTimeout, nil
+	}
+	timeout, err := durationOpt(v)
+	if err != nil || timeout < 0 {
+		return d.mountTimeout, fmt.Errorf("invalid '%s': %v", MountTimeoutOpt, v)
+	}
+	return timeout, nil
+}
+
+// warnIfMountSlow logs a warning if a mount took longer than the driver's
+// threshold.
+func (d *VolumeDriver) warnIfMountSlow(logger lager.Logger, duration time.Duration) {
+	if d.mountDurationWarning <= 0 || duration <= d.mountDurationWarning {
+		return
+	}
+	logger.Error("mount-duration-too-high", nil, lager.Data{
+		"mount-duration-in-second": duration / time.Second,
+		"threshold":                d.mountDurationWarning.String(),
+		"warning":                  "This may result in container creation failure!",
+	})
+}
+
+// mountWithTimeout mounts with the volume's timeout applied to the env's
+// context. An overrun is returned as a *drivererrors.MountTimeoutError, after
+// undoing any mount that the Mounter managed to make.
+func (d *VolumeDriver) mountWithTimeout(env dockerdriver.Env, logger lager.Logger, source, mountPath string, opts map[string]interface{}) error {
+	timeout, err := d.mountTimeoutFor(opts)
+	if err != nil {
+		logger.Error("invalid-mount-timeout", err)
+	}
+	if timeout <= 0 {
+		return d.mountWithRetry(env, logger, source, mountPath, opts)
+	}
+
+	ctx, cancel := context.WithTimeout(env.Context(), timeout)
+	defer cancel()
+
+	err = d.mountWithRetry(driverhttp.EnvWithContext(ctx, env), logger, source, mountPath, opts)
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || env.Context().Err() != nil {
+		return err
+	}
+
+	logger.Error("mount-timed-out", d.redactor.Error(err), lager.Data{"timeout": timeout.String()})
+	if d.stillMounted(logger, mountPath) {
+		if unmountErr := d.unmountNormally(env, mountPath); unmountErr != nil {
+			logger.Error("unmount-after-timeout-failed", d.redactor.Error(unmountErr))
+		}
+	}
+	return &drivererrors.MountTimeoutError{Volume: filepath.Base(mountPath), Timeout: timeout, Err: err}
+}
@@ -0,0 +1,233 @@
+// Package mountclassifier turns the failures of mount helpers such as
+// mount.nfs and mount.cifs into categories with messages that are safe to
+// show to app developers.
+//
+// A Mounter classifies a failed invocation and returns the result:
+//
+//	if err := result.Wait(); err != nil {
+//		return mountclassifier.Wrap(classifier, mountclassifier.FailureOf(result, err))
+//	}
+//
+// The returned error contains a dockerdriver.SafeError, so the volume driver
+// reports its description instead of the raw stderr.
+package mountclassifier
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/dockerdriver"
+)
+
+// Category is a kind of mount failure.
+type Category string
+
+const (
+	CategoryUnknown             Category = "unknown"
+	CategoryAuthFailure         Category = "auth-failure"
+	CategoryExportNotFound      Category = "export-not-found"
+	CategoryNetworkUnreachable  Category = "network-unreachable"
+	CategoryProtocolUnsupported Category = "protocol-unsupported"
+	CategoryTimeout             Category = "timeout"
+)
+
+// SafeDescriptions are shown to the user for each category. They never
+// include anything from the failure itself.
+var SafeDescriptions = map[Category]string{
+	CategoryAuthFailure:         "the file server rejected the credentials or denied access to the share",
+	CategoryExportNotFound:      "the share or export does not exist on the file server",
+	CategoryNetworkUnreachable:  "the file server could not be reached",
+	CategoryProtocolUnsupported: "the file server does not support the requested protocol version",
+	CategoryTimeout:             "timed out waiting for the file server",
+}
+
+// Retryable reports whether a failure in the category may succeed if the
+// mount is attempted again. A wrong password or a missing share will not.
+func (c Category) Retryable() bool {
+	switch c {
+	case CategoryNetworkUnreachable, CategoryTimeout, CategoryUnknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Failure is a failed invocation of a mount helper.
+type Failure struct {
+	// Err is the error the invocation returned.
+	Err error
+	// Stderr is what the helper wrote to stderr, with secrets redacted.
+	Stderr string
+	// ExitCode is the helper's exit code, or -1 if it did not exit normally.
+	ExitCode int
+}
+
+// FailureOf describes a failed invocation from its result, which is usually
+// an invoker.InvokeResult, and the error it returned.
+func FailureOf(result interface{ StdError() string }, err error) Failure {
+	failure := Failure{Err: err, ExitCode: -1}
+	if result != nil {
+		failure.Stderr = result.StdError()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		failure.ExitCode = exitErr.ExitCode()
+	}
+	return failure
+}
+
+// Classifier decides the category of a failure, returning CategoryUnknown
+// if it cannot tell.
+type Classifier interface {
+	Classify(failure Failure) Category
+}
+
+// Rule matches failures that belong to Category. A failure matches if its
+// stderr or error matches any of Patterns, or it exited with any of
+// ExitCodes. Patterns are case-insensitive regular expressions.
+type Rule struct {
+	Category  Category
+	Patterns  []string
+	ExitCodes []int
+}
+
+// DefaultRules cover the messages of mount.nfs and mount.cifs. mount.cifs
+// reports the errno as "mount error(N)". Exit codes are not used, as both
+// helpers exit with 32 for almost every failure.
+var DefaultRules = []Rule{
+	{
+		Category: CategoryTimeout,
+		Patterns: []string{`timed out`, `mount error\(110\)`, `signal: killed`},
+	},
+	{
+		Category: CategoryAuthFailure,
+		Patterns: []string{`access denied`, `permission denied`, `mount error\((1|13)\)`, `NT_STATUS_(LOGON_FAILURE|ACCESS_DENIED|ACCOUNT_)`, `operation not permitted`},
+	},
+	{
+		Category: CategoryExportNotFound,
+		Patterns: []string{`no such file or directory`, `mount error\(2\)`, `bad (export|share) name`, `NT_STATUS_BAD_NETWORK_NAME`},
+	},
+	{
+		Category: CategoryNetworkUnreachable,
+		Patterns: []string{`no route to host`, `network is unreachable`, `host is down`, `connection refused`, `mount error\((101|111|112|113)\)`, `(failed to resolve|could not resolve|resolve failed)`},
+	},
+	{
+		Category: CategoryProtocolUnsupported,
+		Patterns: []string{`protocol not supported`, `version .*not supported`, `not supported by server`, `mount error\((93|95)\)`},
+	},
+}
+
+type compiledRule struct {
+	category  Category
+	patterns  []*regexp.Regexp
+	exitCodes []int
+}
+
+// RuleClassifier classifies failures with the first Rule that matches.
+type RuleClassifier struct {
+	rules []compiledRule
+}
+
+// NewRuleClassifier builds a RuleClassifier from rules, in order.
+func NewRuleClassifier(rules []Rule) (*RuleClassifier, error) {
+	c := &RuleClassifier{}
+	for _, rule := range rules {
+		compiled := compiledRule{category: rule.Category, exitCodes: append([]int{}, rule.ExitCodes...)}
+		for _, pattern := range rule.Patterns {
+			re, err := regexp.Compile(`(?i)` + pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", rule.Category, pattern, err)
+			}
+			compiled.patterns = append(compiled.patterns, re)
+		}
+		c.rules = append(c.rules, compiled)
+	}
+	return c, nil
+}
+
+// Default returns a RuleClassifier configured with DefaultRules.
+func Default() *RuleClassifier {
+	c, err := NewRuleClassifier(DefaultRules)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *RuleClassifier) Classify(failure Failure) Category {
+	text := failure.Stderr
+	if failure.Err != nil {
+		text += "\n" + failure.Err.Error()
+	}
+
+	for _, rule := range c.rules {
+		for _, code := range rule.exitCodes {
+			if failure.ExitCode == code {
+				return rule.category
+			}
+		}
+		for _, re := range rule.patterns {
+			if re.MatchString(text) {
+				return rule.category
+			}
+		}
+	}
+	return CategoryUnknown
+}
+
+// Error is a classified mount failure.
+type Error struct {
+	Category Category
+	Failure  Failure
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("mount failed (%s)", e.Category)
+	if e.Failure.Err != nil {
+		msg += ": " + e.Failure.Err.Error()
+	}
+	if stderr := strings.TrimSpace(e.Failure.Stderr); stderr != "" {
+		msg += ": " + stderr
+	}
+	return msg
+}
+
+// SafeError is the description of the failure that may be shown to the user.
+// It is empty for CategoryUnknown.
+func (e *Error) SafeError() dockerdriver.SafeError {
+	return dockerdriver.SafeError{SafeDescription: SafeDescriptions[e.Category]}
+}
+
+// Unwrap returns the invocation's error and, for a known category, the
+// SafeError, so that errors.As finds either.
+func (e *Error) Unwrap() []error {
+	errs := []error{}
+	if safe := e.SafeError(); safe.SafeDescription != "" {
+		errs = append(errs, safe)
+	}
+	if e.Failure.Err != nil {
+		errs = append(errs, e.Failure.Err)
+	}
+	return errs
+}
+
+// Wrap classifies failure and returns it as an *Error, or nil if the
+// invocation did not fail.
+func Wrap(c Classifier, failure Failure) error {
+	if failure.Err == nil {
+		return nil
+	}
+	return &Error{Category: c.Classify(failure), Failure: failure}
+}
+
+// CategoryOf returns the category of a classified error, or CategoryUnknown.
+func CategoryOf(err error) Category {
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified.Category
+	}
+	return CategoryUnknown
+}
@@ -0,0 +1,114 @@
+package mountclassifier_test
+
+import (
+	"errors"
+	"os/exec"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/volumedriver/invokerfakes"
+	"code.cloudfoundry.org/volumedriver/mountclassifier"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Classifier", func() {
+	var classifier *mountclassifier.RuleClassifier
+
+	BeforeEach(func() {
+		classifier = mountclassifier.Default()
+	})
+
+	DescribeTable("classifying mount helper stderr",
+		func(stderr string, category mountclassifier.Category) {
+			failure := mountclassifier.Failure{Err: errors.New("exit status 32"), Stderr: stderr, ExitCode: 32}
+			Expect(classifier.Classify(failure)).To(Equal(category))
+		},
+		Entry("nfs access denied", "mount.nfs: access denied by server while mounting server:/export", mountclassifier.CategoryAuthFailure),
+		Entry("cifs permission denied", "mount error(13): Permission denied\nRefer to the mount.cifs(8) manual page", mountclassifier.CategoryAuthFailure),
+		Entry("nfs missing export", "mount.nfs: mounting server:/missing failed, reason given by server: No such file or directory", mountclassifier.CategoryExportNotFound),
+		Entry("cifs missing share", "mount error(2): No such file or directory", mountclassifier.CategoryExportNotFound),
+		Entry("no route", "mount.nfs: No route to host", mountclassifier.CategoryNetworkUnreachable),
+		Entry("host down", "mount error(112): Host is down", mountclassifier.CategoryNetworkUnreachable),
+		Entry("unreachable network", "mount.nfs: Network is unreachable", mountclassifier.CategoryNetworkUnreachable),
+		Entry("nfs version", "mount.nfs: requested NFS version or transport protocol is not supported", mountclassifier.CategoryProtocolUnsupported),
+		Entry("cifs dialect", "mount error(95): Operation not supported", mountclassifier.CategoryProtocolUnsupported),
+		Entry("nfs timeout", "mount.nfs: Connection timed out", mountclassifier.CategoryTimeout),
+		Entry("something else", "mount.nfs: an incorrect mount option was specified", mountclassifier.CategoryUnknown),
+	)
+
+	It("classifies by the invocation's error too", func() {
+		Expect(classifier.Classify(mountclassifier.Failure{Err: errors.New("command timed out")})).To(Equal(mountclassifier.CategoryTimeout))
+	})
+
+	Describe("custom rules", func() {
+		It("matches exit codes and applies rules in order", func() {
+			custom, err := mountclassifier.NewRuleClassifier([]mountclassifier.Rule{
+				{Category: mountclassifier.CategoryExportNotFound, ExitCodes: []int{2}},
+				{Category: mountclassifier.CategoryAuthFailure, Patterns: []string{"denied"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(custom.Classify(mountclassifier.Failure{Err: errors.New("failed"), Stderr: "DENIED", ExitCode: 2})).To(Equal(mountclassifier.CategoryExportNotFound))
+			Expect(custom.Classify(mountclassifier.Failure{Err: errors.New("failed"), Stderr: "DENIED", ExitCode: 32})).To(Equal(mountclassifier.CategoryAuthFailure))
+		})
+
+		It("rejects invalid patterns", func() {
+			_, err := mountclassifier.NewRuleClassifier([]mountclassifier.Rule{{Category: mountclassifier.CategoryTimeout, Patterns: []string{"("}}})
+			Expect(err).To(MatchError(ContainSubstring(`invalid timeout pattern "("`)))
+		})
+	})
+
+	Describe("FailureOf", func() {
+		It("takes stderr from the result and the exit code from the error", func() {
+			result := &invokerfakes.FakeInvokeResult{}
+			result.StdErrorReturns("mount error(13): Permission denied")
+			err := exec.Command("sh", "-c", "exit 32").Run()
+
+			failure := mountclassifier.FailureOf(result, err)
+			Expect(failure.Stderr).To(Equal("mount error(13): Permission denied"))
+			Expect(failure.ExitCode).To(Equal(32))
+			Expect(failure.Err).To(Equal(err))
+		})
+
+		It("has no exit code if the helper did not exit", func() {
+			failure := mountclassifier.FailureOf(nil, errors.New("command timed out"))
+			Expect(failure.ExitCode).To(Equal(-1))
+		})
+	})
+
+	Describe("Wrap", func() {
+		It("is nil if the invocation succeeded", func() {
+			Expect(mountclassifier.Wrap(classifier, mountclassifier.Failure{})).To(Succeed())
+		})
+
+		It("returns a classified error carrying a safe description", func() {
+			cause := errors.New("exit status 32")
+			err := mountclassifier.Wrap(classifier, mountclassifier.Failure{Err: cause, Stderr: "mount.nfs: No route to host\n", ExitCode: 32})
+
+			Expect(err).To(MatchError("mount failed (network-unreachable): exit status 32: mount.nfs: No route to host"))
+			Expect(mountclassifier.CategoryOf(err)).To(Equal(mountclassifier.CategoryNetworkUnreachable))
+			Expect(errors.Is(err, cause)).To(BeTrue())
+
+			var safe dockerdriver.SafeError
+			Expect(errors.As(err, &safe)).To(BeTrue())
+			Expect(safe.SafeDescription).To(Equal("the file server could not be reached"))
+		})
+
+		It("has no safe description for unknown failures", func() {
+			err := mountclassifier.Wrap(classifier, mountclassifier.Failure{Err: errors.New("exit status 32"), Stderr: "something odd"})
+
+			var safe dockerdriver.SafeError
+			Expect(errors.As(err, &safe)).To(BeFalse())
+		})
+	})
+
+	It("only retries categories a retry can fix", func() {
+		Expect(mountclassifier.CategoryNetworkUnreachable.Retryable()).To(BeTrue())
+		Expect(mountclassifier.CategoryTimeout.Retryable()).To(BeTrue())
+		Expect(mountclassifier.CategoryUnknown.Retryable()).To(BeTrue())
+		Expect(mountclassifier.CategoryAuthFailure.Retryable()).To(BeFalse())
+		Expect(mountclassifier.CategoryExportNotFound.Retryable()).To(BeFalse())
+		Expect(mountclassifier.CategoryProtocolUnsupported.Retryable()).To(BeFalse())
+		Expect(mountclassifier.CategoryOf(errors.New("plain"))).To(Equal(mountclassifier.CategoryUnknown))
+	})
+})
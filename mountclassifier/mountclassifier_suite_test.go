@@ -0,0 +1,13 @@
+package mountclassifier_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestMountclassifier(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mountclassifier Suite")
+}
@@ -0,0 +1,44 @@
+package volumedriver
+
+import "code.cloudfoundry.org/volumedriver/redactor"
+
+// Option configures optional behaviour of a VolumeDriver. Options are applied
+// by NewVolumeDriver before any state is restored.
+type Option func(*VolumeDriver)
+
+// WithReconcileAction sets what NewVolumeDriver does with a restored volume
+// whose recorded mount is missing from the mount table. Defaults to
+// ReconcileResetCounts.
+func WithReconcileAction(action ReconcileAction) Option {
+	return func(d *VolumeDriver) {
+		d.reconcileAction = action
+	}
+}
+
+// WithOrphanSweep sets what NewVolumeDriver does with mounts and directories
+// under the mount root that no volume refers to. Defaults to SweepDryRun.
+func WithOrphanSweep(mode SweepMode) Option {
+	return func(d *VolumeDriver) {
+		d.sweepMode = mode
+	}
+}
+
+// WithRedactor replaces the redactor used to mask secrets in logs and error
+// responses. Its sensitive keys also decide which Create options are sealed
+// before being persisted. Defaults to redactor.Default().
+func WithRedactor(r *redactor.Redactor) Option {
+	return func(d *VolumeDriver) {
+		d.redactor = r
+	}
+}
+
+// configuredDriver returns a driver with nothing but opts and the defaults
+// they override applied, so that they can be inspected before NewVolumeDriver
+// has run.
+func configuredDriver(opts []Option) *VolumeDriver {
+	d := &VolumeDriver{permissions: DefaultPermissions}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
@@ -12,6 +12,8 @@ import (
 type osHelper struct {
 }
 
+// Deprecated: the volume driver no longer changes the umask, so it has no
+// use for an OsHelper.
 func NewOsHelper() volumedriver.OsHelper {
 	return &osHelper{}
 }
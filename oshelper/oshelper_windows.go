@@ -8,6 +8,8 @@ import "code.cloudfoundry.org/volumedriver"
 type osHelper struct {
 }
 
+// Deprecated: the volume driver no longer changes the umask, so it has no
+// use for an OsHelper.
 func NewOsHelper() volumedriver.OsHelper {
 	return &osHelper{}
 }
@@ -0,0 +1,162 @@
+package volumedriver
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"runtime"
+
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Owner is a numeric user and group to chown a file or directory to.
+type Owner struct {
+	UID int
+	GID int
+}
+
+// FileSpec is the mode, and optionally the owner, given to a file or
+// directory created by the driver. A nil Owner leaves ownership to the
+// driver process.
+type FileSpec struct {
+	Mode  os.FileMode
+	Owner *Owner
+}
+
+// Permissions sets the mode and ownership of what the driver creates.
+type Permissions struct {
+	// StateFile applies to the state file and anything else a built-in
+	// StateStore writes, such as its backup or journal.
+	StateFile FileSpec
+	// MountRoot applies to the mount path root and the state directory.
+	MountRoot FileSpec
+	// MountDir applies to the per-volume directories volumes are mounted on.
+	MountDir FileSpec
+	// Repair fixes the mode and ownership of an existing mount root and state
+	// file at startup. Otherwise a mismatch is only logged.
+	Repair bool
+}
+
+// DefaultPermissions keep the state private to the driver, while still
+// letting other users traverse the mount root to reach their mounts.
+var DefaultPermissions = Permissions{
+	StateFile: FileSpec{Mode: 0600},
+	MountRoot: FileSpec{Mode: 0755},
+	MountDir:  FileSpec{Mode: 0755},
+}
+
+// WithPermissions sets the mode and ownership of the state file, the mount
+// root and the per-volume mount directories. Defaults to DefaultPermissions.
+func WithPermissions(p Permissions) Option {
+	return func(d *VolumeDriver) {
+		d.permissions = p
+	}
+}
+
+// fileStateStore is implemented by the built-in stores that keep their state
+// in files, so that the driver's Permissions also apply to them.
+type fileStateStore interface {
+	StateStore
+	setPermissions(file, dir FileSpec)
+	stateFiles() []string
+}
+
+// applyOwner chowns path if spec asks for it.
+func applyOwner(os osshim.Os, path string, spec FileSpec) error {
+	if spec.Owner == nil {
+		return nil
+	}
+	return os.Chown(path, spec.Owner.UID, spec.Owner.GID)
+}
+
+// applyFileSpec gives path exactly the mode of spec, and its owner if set.
+// The mode passed when creating a file is masked by the process umask, and
+// the umask is shared by every goroutine, so the driver sets the mode
+// explicitly afterwards instead of changing the umask.
+func applyFileSpec(os osshim.Os, path string, spec FileSpec) error {
+	if err := os.Chmod(path, spec.Mode.Perm()); err != nil {
+		return err
+	}
+	return applyOwner(os, path, spec)
+}
+
+// mkdirAll creates dir and any missing parents, giving dir the mode and owner
+// of spec. An existing dir is left alone; see checkPermissions.
+func mkdirAll(os osshim.Os, dir string, spec FileSpec) error {
+	_, err := os.Stat(dir)
+	if err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, spec.Mode.Perm()); err != nil {
+		return err
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		// It may have been there all along.
+		return nil
+	}
+	return applyFileSpec(os, dir, spec)
+}
+
+// checkPermissions compares the mount root and the state files with the
+// configured Permissions, repairing them if asked to. Missing files are fine:
+// they will be created with the right permissions.
+func (d *VolumeDriver) checkPermissions(logger lager.Logger) {
+	if runtime.GOOS == "windows" {
+		// Windows only reports the read-only bit through os.FileMode.
+		return
+	}
+
+	logger = logger.Session("check-permissions", lager.Data{"repair": d.permissions.Repair})
+
+	d.checkFilePermissions(logger, d.mountPathRoot, d.permissions.MountRoot)
+	if store, ok := d.stateStore.(fileStateStore); ok {
+		for _, path := range store.stateFiles() {
+			d.checkFilePermissions(logger, path, d.permissions.StateFile)
+		}
+	}
+}
+
+func (d *VolumeDriver) checkFilePermissions(logger lager.Logger, path string, spec FileSpec) {
+	info, err := d.os.Stat(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error("failed-to-stat", err, lager.Data{"path": path})
+		}
+		return
+	}
+
+	mode := info.Mode().Perm()
+	uid, gid, hasOwner := fileOwner(info)
+	wrongMode := mode != spec.Mode.Perm()
+	wrongOwner := spec.Owner != nil && hasOwner && (uid != spec.Owner.UID || gid != spec.Owner.GID)
+	if !wrongMode && !wrongOwner {
+		return
+	}
+
+	data := lager.Data{"path": path, "mode": mode.String(), "expected-mode": spec.Mode.Perm().String()}
+	if wrongOwner {
+		data["uid"], data["gid"] = uid, gid
+		data["expected-uid"], data["expected-gid"] = spec.Owner.UID, spec.Owner.GID
+	}
+
+	if !d.permissions.Repair {
+		logger.Info("unexpected-permissions", data)
+		return
+	}
+
+	if wrongMode {
+		if err := d.os.Chmod(path, spec.Mode.Perm()); err != nil {
+			logger.Error("failed-to-repair-mode", err, data)
+			return
+		}
+	}
+	if wrongOwner {
+		if err := applyOwner(d.os, path, spec); err != nil {
+			logger.Error("failed-to-repair-owner", err, data)
+			return
+		}
+	}
+	logger.Info("repaired-permissions", data)
+}
@@ -0,0 +1,106 @@
+package volumedriver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/internal/sealer"
+)
+
+// WithOptsEncryptionKey enables persisting the values of sensitive Create
+// options (as decided by the driver's redactor), sealed with key, so that a
+// restarted driver can remount volumes that containers still reference.
+// Without a key those values are not persisted at all. An empty key makes
+// NewLockedVolumeDriver fail; NewVolumeDriver logs it and carries on as if
+// there were no key.
+func WithOptsEncryptionKey(key []byte) Option {
+	return func(d *VolumeDriver) {
+		d.optsEncryptionKey = key
+	}
+}
+
+// checkOptsEncryptionKey returns the error NewVolumeDriver would log for the
+// key set by opts, if any.
+func checkOptsEncryptionKey(opts []Option) error {
+	d := configuredDriver(opts)
+	if d.optsEncryptionKey == nil {
+		return nil
+	}
+	if _, err := sealer.New(d.optsEncryptionKey); err != nil {
+		return fmt.Errorf("invalid opts encryption key: %w", err)
+	}
+	return nil
+}
+
+// sealOpts returns the copy of opts that is safe to persist.
+func (d *VolumeDriver) sealOpts(logger lager.Logger, opts map[string]interface{}) map[string]interface{} {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	persisted := make(map[string]interface{}, len(opts))
+	for key, value := range opts {
+		if !d.redactor.IsSensitiveKey(key) {
+			persisted[key] = value
+			continue
+		}
+
+		if d.optsSealer == nil {
+			logger.Info("sensitive-opt-not-persisted", lager.Data{"key": key})
+			continue
+		}
+
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			logger.Error("failed-to-marshal-opt", err, lager.Data{"key": key})
+			continue
+		}
+
+		sealed, err := d.optsSealer.Seal(plaintext)
+		if err != nil {
+			logger.Error("failed-to-seal-opt", err, lager.Data{"key": key})
+			continue
+		}
+		persisted[key] = sealed
+	}
+
+	return persisted
+}
+
+// openOpts reverses sealOpts. Values that cannot be unsealed, for example
+// because the key has changed, are dropped rather than handed to the mounter.
+func (d *VolumeDriver) openOpts(logger lager.Logger, persisted map[string]interface{}) map[string]interface{} {
+	if persisted == nil {
+		return nil
+	}
+
+	opts := make(map[string]interface{}, len(persisted))
+	for key, value := range persisted {
+		sealed, ok := value.(string)
+		if !ok || !sealer.IsSealed(sealed) {
+			opts[key] = value
+			continue
+		}
+
+		if d.optsSealer == nil {
+			logger.Info("sealed-opt-without-key", lager.Data{"key": key})
+			continue
+		}
+
+		plaintext, err := d.optsSealer.Open(sealed)
+		if err != nil {
+			logger.Error("failed-to-unseal-opt", err, lager.Data{"key": key})
+			continue
+		}
+
+		var opened interface{}
+		if err := json.Unmarshal(plaintext, &opened); err != nil {
+			logger.Error("failed-to-unmarshal-opt", err, lager.Data{"key": key})
+			continue
+		}
+		opts[key] = opened
+	}
+
+	return opts
+}
@@ -0,0 +1,117 @@
+package volumedriver
+
+import (
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/metrics"
+)
+
+// ReconcileAction is what the driver does with a restored volume that claims
+// to be mounted when the kernel mount is missing, e.g. after a cell reboot.
+type ReconcileAction string
+
+const (
+	// ReconcileResetCounts forgets the stale mount: the refcount is reset and
+	// the mountpoint cleared, so the next Mount performs a fresh mount.
+	ReconcileResetCounts ReconcileAction = "reset-counts"
+	// ReconcileRemount mounts the volume again straight away, falling back to
+	// ReconcileMarkDegraded when the remount fails.
+	ReconcileRemount ReconcileAction = "remount"
+	// ReconcileMarkDegraded keeps the refcount but flags the volume so that
+	// it is remounted by the next Mount.
+	ReconcileMarkDegraded ReconcileAction = "mark-degraded"
+)
+
+// ReconciliationReport describes what NewVolumeDriver found when comparing
+// the restored state with the live mount table, and what it changed.
+type ReconciliationReport struct {
+	Action     ReconcileAction
+	Checked    int
+	Mismatches []ReconciliationEntry
+}
+
+type ReconciliationEntry struct {
+	Volume     string
+	Mountpoint string
+	MountCount int
+	// Action is the action actually taken, which may differ from the
+	// configured one when it failed.
+	Action ReconcileAction
+	Error  string `json:",omitempty"`
+}
+
+// ReconciliationReport returns the result of the reconciliation performed when
+// the driver was constructed.
+func (d *VolumeDriver) ReconciliationReport() ReconciliationReport {
+	return d.reconciliationReport
+}
+
+func (d *VolumeDriver) reconcileState(env dockerdriver.Env) ReconciliationReport {
+	logger := env.Logger().Session("reconcile-state", lager.Data{"action": d.reconcileAction})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := ReconciliationReport{Action: d.reconcileAction, Mismatches: []ReconciliationEntry{}}
+
+	for _, volume := range d.volumes.Values() {
+		if volume.MountCount < 1 || volume.Mountpoint == "" {
+			continue
+		}
+		report.Checked++
+
+		exists, err := d.mountChecker.Exists(volume.Mountpoint)
+		if err != nil {
+			// Without a reliable answer, leave the volume alone rather than guess.
+			logger.Error("failed-proc-mounts-check", err, lager.Data{"volume": volume.Name, "mountpoint": volume.Mountpoint})
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		entry := ReconciliationEntry{
+			Volume:     volume.Name,
+			Mountpoint: volume.Mountpoint,
+			MountCount: volume.MountCount,
+			Action:     d.reconcileAction,
+		}
+
+		switch d.reconcileAction {
+		case ReconcileRemount:
+			start := d.time.Now()
+			err := d.mount(env, copyOpts(volume.Opts), volume.Mountpoint)
+			d.recordMount(volume.Name, start, d.time.Now(), err)
+			d.metrics.ObserveRemount(metrics.RemountOnReconcile, outcomeOf(err))
+			if err != nil {
+				err = d.redactor.Error(err)
+				logger.Error("remount-failed", err, lager.Data{"volume": volume.Name})
+				entry.Action = ReconcileMarkDegraded
+				entry.Error = err.Error()
+				volume.Degraded = true
+			} else {
+				volume.Degraded = false
+			}
+		case ReconcileMarkDegraded:
+			volume.Degraded = true
+		default:
+			entry.Action = ReconcileResetCounts
+			volume.MountCount = 0
+			volume.MountIDs = nil
+			volume.Mountpoint = ""
+			volume.Degraded = false
+		}
+
+		d.volumes.Put(volume.Name, volume)
+		report.Mismatches = append(report.Mismatches, entry)
+		logger.Info("reconciled-volume", lager.Data{"entry": entry})
+	}
+
+	if len(report.Mismatches) > 0 {
+		if err := d.persistState(env); err != nil {
+			logger.Error("persist-state-failed", err)
+		}
+	}
+
+	logger.Info("report", lager.Data{"report": report})
+	return report
+}
@@ -0,0 +1,13 @@
+package redactor_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestRedactor(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Redactor Suite")
+}
@@ -0,0 +1,231 @@
+package volumedriver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/goshims/timeshim"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	stateFileName       = "driver-state.json"
+	stateFileTempSuffix = ".tmp"
+	stateFileBackSuffix = ".bak"
+)
+
+// JSONFileStateStore keeps the whole state in a single JSON document,
+// driver-state.json, which is replaced atomically on every save. The previous
+// generation is kept as driver-state.json.bak and used if the current one is
+// missing or unreadable.
+type JSONFileStateStore struct {
+	os       osshim.Os
+	filepath filepathshim.Filepath
+	time     timeshim.Time
+	dir      string
+	fileSpec FileSpec
+	dirSpec  FileSpec
+}
+
+// NewJSONFileStateStore returns a store that writes driver-state.json in dir,
+// creating dir if needed. Files and dir get DefaultPermissions unless the
+// driver is given others.
+func NewJSONFileStateStore(os osshim.Os, filepath filepathshim.Filepath, time timeshim.Time, dir string) *JSONFileStateStore {
+	return &JSONFileStateStore{
+		os:       os,
+		filepath: filepath,
+		time:     time,
+		dir:      dir,
+		fileSpec: DefaultPermissions.StateFile,
+		dirSpec:  DefaultPermissions.MountRoot,
+	}
+}
+
+func (s *JSONFileStateStore) setPermissions(file, dir FileSpec) {
+	s.fileSpec = file
+	s.dirSpec = dir
+}
+
+func (s *JSONFileStateStore) stateFiles() []string {
+	stateFile := filepath.Join(s.dir, stateFileName)
+	return []string{stateFile, stateFile + stateFileBackSuffix}
+}
+
+func (s *JSONFileStateStore) Load(logger lager.Logger) (map[string]NfsVolumeInfo, error) {
+	volumes, _, err := s.load(logger)
+	return volumes, err
+}
+
+func (s *JSONFileStateStore) Save(logger lager.Logger, volumes map[string]NfsVolumeInfo) error {
+	return s.save(logger, volumes, 0)
+}
+
+func (s *JSONFileStateStore) load(logger lager.Logger) (map[string]NfsVolumeInfo, stateEnvelope, error) {
+	stateFile := filepath.Join(s.dir, stateFileName)
+
+	var (
+		volumes  map[string]NfsVolumeInfo
+		envelope stateEnvelope
+	)
+	_, err := s.readFileWithBackup(logger, stateFile, func(data []byte) error {
+		var err error
+		volumes, envelope, err = decodeState(data)
+		return err
+	})
+	if err != nil {
+		return nil, envelope, err
+	}
+
+	logger.Info("loaded-state", lager.Data{"state-file": stateFile, "version": envelope.Version, "driver-version": envelope.DriverVersion, "written-at": envelope.WrittenAt})
+	return volumes, envelope, nil
+}
+
+func (s *JSONFileStateStore) save(logger lager.Logger, volumes map[string]NfsVolumeInfo, sequence uint64) error {
+	dir, err := s.filepath.Abs(s.dir)
+	if err != nil {
+		logger.Error("abs-failed", err)
+		return err
+	}
+	if err := mkdirAll(s.os, dir, s.dirSpec); err != nil {
+		logger.Error("mkdir-state-dir-failed", err)
+		return err
+	}
+	stateFile := filepath.Join(dir, stateFileName)
+
+	stateData, err := encodeState(volumes, s.time.Now(), sequence)
+	if err != nil {
+		logger.Error("failed-to-marshall-state", err)
+		return err
+	}
+
+	if err := s.writeFileAtomically(logger, stateFile, stateData, s.fileSpec); err != nil {
+		logger.Error("failed-to-write-state-file", err, lager.Data{"stateFile": stateFile})
+		return err
+	}
+
+	logger.Debug("state-saved", lager.Data{"state-file": stateFile})
+	return nil
+}
+
+// writeFileAtomically replaces path with data without ever exposing a
+// partially written file. The data is written and fsynced to a temporary file
+// next to path, the current generation is kept as path.bak, and the temporary
+// file is renamed into place before the containing directory is fsynced.
+func (s *JSONFileStateStore) writeFileAtomically(logger lager.Logger, path string, data []byte, spec FileSpec) error {
+	tempFile := path + stateFileTempSuffix
+	backupFile := path + stateFileBackSuffix
+
+	f, err := s.os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, spec.Mode)
+	if err != nil {
+		logger.Error("failed-to-open-temp-file", err, lager.Data{"file": tempFile})
+		return err
+	}
+
+	if err := applyFileSpec(s.os, tempFile, spec); err != nil {
+		_ = f.Close()
+		logger.Error("failed-to-set-temp-file-permissions", err, lager.Data{"file": tempFile})
+		if rmErr := s.os.Remove(tempFile); rmErr != nil {
+			logger.Error("failed-to-remove-temp-file", rmErr, lager.Data{"file": tempFile})
+		}
+		return err
+	}
+
+	if err := writeAndSync(f, data); err != nil {
+		logger.Error("failed-to-write-temp-file", err, lager.Data{"file": tempFile})
+		if rmErr := s.os.Remove(tempFile); rmErr != nil {
+			logger.Error("failed-to-remove-temp-file", rmErr, lager.Data{"file": tempFile})
+		}
+		return err
+	}
+
+	if err := s.os.Rename(path, backupFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		// Losing the backup generation must not stop the new state from being written.
+		logger.Error("failed-to-keep-backup", err, lager.Data{"file": backupFile})
+	}
+
+	if err := s.os.Rename(tempFile, path); err != nil {
+		logger.Error("failed-to-rename-temp-file", err, lager.Data{"from": tempFile, "to": path})
+		return err
+	}
+
+	if err := syncDir(s.os, filepath.Dir(path)); err != nil {
+		// The rename has happened; only its durability across a power loss is in doubt.
+		logger.Error("failed-to-sync-state-dir", err, lager.Data{"dir": filepath.Dir(path)})
+	}
+
+	return nil
+}
+
+// readFileWithBackup returns the contents of path, falling back to the backup
+// generation kept by writeFileAtomically when path is missing or fails the
+// supplied validation.
+func (s *JSONFileStateStore) readFileWithBackup(logger lager.Logger, path string, valid func([]byte) error) ([]byte, error) {
+	data, err := s.os.ReadFile(path)
+	if err == nil {
+		if err = valid(data); err == nil {
+			return data, nil
+		}
+
+		// An older generation is no substitute for a file this driver cannot understand.
+		var unsupported *UnsupportedStateVersionError
+		if errors.As(err, &unsupported) {
+			return nil, err
+		}
+		logger.Error("invalid-state-file", err, lager.Data{"file": path})
+	} else {
+		logger.Info("failed-to-read-state-file", lager.Data{"err": err, "file": path})
+	}
+
+	backupFile := path + stateFileBackSuffix
+	backupData, backupErr := s.os.ReadFile(backupFile)
+	if backupErr != nil {
+		logger.Info("failed-to-read-backup-file", lager.Data{"err": backupErr, "file": backupFile})
+		return nil, err
+	}
+	if backupErr = valid(backupData); backupErr != nil {
+		logger.Error("invalid-backup-file", backupErr, lager.Data{"file": backupFile})
+		return nil, err
+	}
+
+	logger.Info("using-backup-file", lager.Data{"file": backupFile})
+	return backupData, nil
+}
+
+func syncDir(os osshim.Os, dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return syncFile(f)
+}
+
+func writeAndSync(f osshim.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := syncFile(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// syncFile flushes f to stable storage. osshim.File does not expose Sync, so
+// reach through to the underlying *os.File when there is one.
+func syncFile(f osshim.File) error {
+	switch file := f.(type) {
+	case interface{ Sync() error }:
+		return file.Sync()
+	case *osshim.FileShim:
+		return file.Delegate.Sync()
+	}
+	return nil
+}
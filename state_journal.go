@@ -0,0 +1,346 @@
+package volumedriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/goshims/timeshim"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const journalFileName = "driver-state.journal"
+
+const (
+	journalOpCreate = "create"
+	journalOpUpdate = "update"
+	journalOpIncref = "incref"
+	journalOpDecref = "decref"
+	journalOpDelete = "delete"
+)
+
+// JournalConfig sets when a JournalStateStore compacts its journal.
+type JournalConfig struct {
+	// MaxRecords is the number of records after which the journal is
+	// compacted. Defaults to 1000.
+	MaxRecords int
+	// MaxBytes is the journal size after which it is compacted. Defaults to 1MiB.
+	MaxBytes int64
+}
+
+// JournalStateStore persists state as a snapshot plus a write-ahead journal
+// of the changes made since. Each change appends one small record instead of
+// rewriting every volume; once the journal passes a JournalConfig threshold
+// it is folded into a new snapshot and truncated.
+//
+// The snapshot is driver-state.json in the same format as JSONFileStateStore,
+// so a driver can switch from that store to this one without losing state.
+type JournalStateStore struct {
+	os       osshim.Os
+	snapshot *JSONFileStateStore
+	config   JournalConfig
+	dir      string
+	fileSpec FileSpec
+	dirSpec  FileSpec
+
+	lock     sync.Mutex
+	volumes  map[string]NfsVolumeInfo
+	sequence uint64
+	records  int
+	bytes    int64
+	// compact is set when the journal on disk cannot be appended to as it
+	// is, for example because it ends in a torn record.
+	compact bool
+}
+
+type journalRecord struct {
+	Version    int            `json:"version"`
+	Sequence   uint64         `json:"seq"`
+	Op         string         `json:"op"`
+	Name       string         `json:"name"`
+	Mountpoint string         `json:"mountpoint,omitempty"`
+	Volume     *NfsVolumeInfo `json:"volume,omitempty"`
+}
+
+// NewJournalStateStore returns a store that keeps its snapshot and journal
+// in dir, creating dir if needed.
+func NewJournalStateStore(os osshim.Os, filepath filepathshim.Filepath, time timeshim.Time, dir string, config JournalConfig) *JournalStateStore {
+	if config.MaxRecords <= 0 {
+		config.MaxRecords = 1000
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = 1 << 20
+	}
+
+	return &JournalStateStore{
+		os:       os,
+		snapshot: NewJSONFileStateStore(os, filepath, time, dir),
+		config:   config,
+		dir:      dir,
+		fileSpec: DefaultPermissions.StateFile,
+		dirSpec:  DefaultPermissions.MountRoot,
+		volumes:  map[string]NfsVolumeInfo{},
+	}
+}
+
+// Load replays the journal on top of the snapshot. Records already folded
+// into the snapshot are skipped, and a torn final record is dropped.
+func (s *JournalStateStore) Load(logger lager.Logger) (map[string]NfsVolumeInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Whatever happens, the next write starts the journal afresh.
+	s.compact = true
+
+	volumes, envelope, snapshotErr := s.snapshot.load(logger)
+	if snapshotErr != nil && !errors.Is(snapshotErr, os.ErrNotExist) {
+		return nil, snapshotErr
+	}
+	if volumes == nil {
+		volumes = map[string]NfsVolumeInfo{}
+	}
+
+	data, err := s.os.ReadFile(s.journalPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || snapshotErr != nil {
+			return nil, err
+		}
+		data = nil
+	}
+
+	sequence := envelope.Sequence
+	records, torn := 0, false
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			if i == len(lines)-1 {
+				// A crash part way through an append leaves a torn last record.
+				// Its change was never acknowledged, so it is safe to drop.
+				logger.Error("ignoring-torn-journal-record", err, lager.Data{"journal": s.journalPath(), "line": i + 1})
+				torn = true
+				break
+			}
+			return nil, fmt.Errorf("corrupt journal record at line %d: %w", i+1, err)
+		}
+
+		if record.Version > CurrentStateVersion {
+			return nil, &UnsupportedStateVersionError{Version: record.Version}
+		}
+		if record.Sequence <= envelope.Sequence {
+			// Already in the snapshot; the journal was not truncated after compaction.
+			continue
+		}
+
+		if err := applyJournalRecord(volumes, record); err != nil {
+			return nil, fmt.Errorf("journal record at line %d: %w", i+1, err)
+		}
+		sequence = record.Sequence
+		records++
+	}
+
+	s.volumes = volumes
+	s.sequence = sequence
+	s.records = records
+	s.bytes = int64(len(data))
+	s.compact = torn
+
+	logger.Info("replayed-journal", lager.Data{"journal": s.journalPath(), "snapshot-sequence": envelope.Sequence, "records": records, "sequence": sequence})
+	return copyVolumes(volumes), nil
+}
+
+// Save replaces the snapshot with volumes and truncates the journal.
+func (s *JournalStateStore) Save(logger lager.Logger, volumes map[string]NfsVolumeInfo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.volumes = copyVolumes(volumes)
+	return s.compactJournal(logger)
+}
+
+// PutVolume appends the smallest record that turns the stored volume into
+// volume: an incref or decref for a Mount or Unmount, otherwise the whole volume.
+func (s *JournalStateStore) PutVolume(logger lager.Logger, volume NfsVolumeInfo) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record := journalRecord{Op: journalOpCreate, Name: volume.Name, Volume: &volume}
+	if previous, ok := s.volumes[volume.Name]; ok {
+		record = journalRecord{Op: journalOpUpdate, Name: volume.Name, Volume: &volume}
+		for _, candidate := range []journalRecord{
+			{Op: journalOpIncref, Name: volume.Name, Mountpoint: volume.Mountpoint},
+			{Op: journalOpDecref, Name: volume.Name},
+		} {
+			if sameVolume(applyRecordTo(previous, candidate), volume) {
+				record = candidate
+				break
+			}
+		}
+	}
+
+	return s.append(logger, record)
+}
+
+func (s *JournalStateStore) DeleteVolume(logger lager.Logger, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.append(logger, journalRecord{Op: journalOpDelete, Name: name})
+}
+
+func (s *JournalStateStore) append(logger lager.Logger, record journalRecord) error {
+	record.Version = CurrentStateVersion
+	record.Sequence = s.sequence + 1
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.Error("failed-to-marshall-journal-record", err)
+		return err
+	}
+	data = append(data, '\n')
+
+	if s.compact {
+		if err := s.compactJournal(logger); err != nil {
+			return err
+		}
+	}
+
+	if err := mkdirAll(s.os, s.dir, s.dirSpec); err != nil {
+		logger.Error("mkdir-state-dir-failed", err)
+		return err
+	}
+
+	f, err := s.os.OpenFile(s.journalPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, s.fileSpec.Mode)
+	if err != nil {
+		logger.Error("failed-to-open-journal", err, lager.Data{"journal": s.journalPath()})
+		return err
+	}
+
+	if s.bytes == 0 {
+		// A new journal; make sure it has the right mode and owner.
+		if err := applyFileSpec(s.os, s.journalPath(), s.fileSpec); err != nil {
+			_ = f.Close()
+			logger.Error("failed-to-set-journal-permissions", err, lager.Data{"journal": s.journalPath()})
+			return err
+		}
+	}
+
+	if err := writeAndSync(f, data); err != nil {
+		logger.Error("failed-to-append-journal-record", err, lager.Data{"journal": s.journalPath()})
+		// The record may be partly on disk; start afresh before the next one.
+		s.compact = true
+		return err
+	}
+
+	if err := applyJournalRecord(s.volumes, record); err != nil {
+		logger.Error("failed-to-apply-journal-record", err, lager.Data{"record": record.Sequence})
+	}
+	s.sequence = record.Sequence
+	s.records++
+	s.bytes += int64(len(data))
+
+	if s.records >= s.config.MaxRecords || s.bytes >= s.config.MaxBytes {
+		if err := s.compactJournal(logger); err != nil {
+			// The record is durable in the journal; compaction will be retried.
+			logger.Error("failed-to-compact-journal", err)
+		}
+	}
+	return nil
+}
+
+// compactJournal writes every volume to a new snapshot and then truncates
+// the journal. The snapshot records the last sequence number it includes, so
+// a crash before the truncation only leaves records that Load will skip.
+func (s *JournalStateStore) compactJournal(logger lager.Logger) error {
+	logger = logger.Session("compact-journal", lager.Data{"records": s.records, "bytes": s.bytes, "sequence": s.sequence})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if err := s.snapshot.save(logger, s.volumes, s.sequence); err != nil {
+		s.compact = true
+		return err
+	}
+
+	if err := s.os.Remove(s.journalPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error("failed-to-truncate-journal", err, lager.Data{"journal": s.journalPath()})
+		s.compact = true
+		return err
+	}
+
+	s.records = 0
+	s.bytes = 0
+	s.compact = false
+	return nil
+}
+
+func (s *JournalStateStore) setPermissions(file, dir FileSpec) {
+	s.fileSpec = file
+	s.dirSpec = dir
+	s.snapshot.setPermissions(file, dir)
+}
+
+func (s *JournalStateStore) stateFiles() []string {
+	return append(s.snapshot.stateFiles(), s.journalPath())
+}
+
+func (s *JournalStateStore) journalPath() string {
+	return filepath.Join(s.dir, journalFileName)
+}
+
+func applyJournalRecord(volumes map[string]NfsVolumeInfo, record journalRecord) error {
+	switch record.Op {
+	case journalOpCreate, journalOpUpdate:
+		if record.Volume == nil {
+			return fmt.Errorf("%s of %q has no volume", record.Op, record.Name)
+		}
+		volumes[record.Name] = *record.Volume
+	case journalOpIncref, journalOpDecref:
+		volume, ok := volumes[record.Name]
+		if !ok {
+			return fmt.Errorf("%s of unknown volume %q", record.Op, record.Name)
+		}
+		volumes[record.Name] = applyRecordTo(volume, record)
+	case journalOpDelete:
+		delete(volumes, record.Name)
+	default:
+		return fmt.Errorf("unknown op %q", record.Op)
+	}
+	return nil
+}
+
+func applyRecordTo(volume NfsVolumeInfo, record journalRecord) NfsVolumeInfo {
+	switch record.Op {
+	case journalOpIncref:
+		volume.MountCount++
+		volume.Mountpoint = record.Mountpoint
+		volume.Degraded = false
+	case journalOpDecref:
+		volume.MountCount--
+	}
+	return volume
+}
+
+// sameVolume compares volumes as they are persisted.
+func sameVolume(a, b NfsVolumeInfo) bool {
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aData, bData)
+}
+
+func copyVolumes(volumes map[string]NfsVolumeInfo) map[string]NfsVolumeInfo {
+	result := make(map[string]NfsVolumeInfo, len(volumes))
+	for name, volume := range volumes {
+		result[name] = volume
+	}
+	return result
+}
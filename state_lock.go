@@ -0,0 +1,93 @@
+package volumedriver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/goshims/timeshim"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/internal/statelock"
+	"code.cloudfoundry.org/volumedriver/mountchecker"
+)
+
+const lockFileName = "driver.lock"
+
+// StateLockedError is returned by NewLockedVolumeDriver when another driver
+// process already owns the mount path root.
+type StateLockedError struct {
+	LockFile string
+	// PID of the other driver, or 0 if it could not be determined.
+	PID int
+}
+
+func (e *StateLockedError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("another volume driver is already running against %s (lock file %s)", filepath.Dir(e.LockFile), e.LockFile)
+	}
+	return fmt.Sprintf("another volume driver (pid %d) is already running against %s (lock file %s)", e.PID, filepath.Dir(e.LockFile), e.LockFile)
+}
+
+// NewLockedVolumeDriver is NewVolumeDriver for drivers that must not share
+// their mount path root with another process. It takes an exclusive lock on
+// driver.lock in mountPathRoot before restoring any state, and fails with a
+// *StateLockedError if another driver holds it. The lock is released by
+// Drain or Close. The mount path root and the lock file are created with the
+// driver's Permissions. It also fails, before taking the lock, if the opts
+// encryption key is invalid.
+func NewLockedVolumeDriver(logger lager.Logger, os osshim.Os, filepath filepathshim.Filepath, time timeshim.Time, mountChecker mountchecker.MountChecker, mountPathRoot string, mounter Mounter, oshelper OsHelper, opts ...Option) (*VolumeDriver, error) {
+	if err := checkOptsEncryptionKey(opts); err != nil {
+		logger.Error("invalid-opts-encryption-key", err)
+		return nil, err
+	}
+
+	permissions := configuredDriver(opts).permissions
+	if err := mkdirAll(os, mountPathRoot, permissions.MountRoot); err != nil {
+		logger.Error("mkdir-mount-root-failed", err, lager.Data{"mount-root": mountPathRoot})
+		return nil, err
+	}
+
+	lockFile := lockFilePath(mountPathRoot)
+	_, statErr := os.Stat(lockFile)
+
+	lock, err := statelock.Acquire(lockFile, permissions.StateFile.Mode.Perm())
+	if err != nil {
+		var held *statelock.HeldError
+		if errors.As(err, &held) {
+			err = &StateLockedError{LockFile: held.Path, PID: held.PID}
+		}
+		logger.Error("failed-to-lock-state-dir", err, lager.Data{"lock-file": lockFile})
+		return nil, err
+	}
+	logger.Info("locked-state-dir", lager.Data{"lock-file": lockFile})
+	if errors.Is(statErr, fs.ErrNotExist) {
+		if err := applyFileSpec(os, lockFile, permissions.StateFile); err != nil {
+			logger.Error("failed-to-set-lock-file-permissions", err, lager.Data{"lock-file": lockFile})
+		}
+	}
+
+	d := NewVolumeDriver(logger, os, filepath, time, mountChecker, mountPathRoot, mounter, oshelper, opts...)
+	d.stateLock = lock
+	return d, nil
+}
+
+// Close stops the health monitor and any scheduled teardowns, and releases
+// the lock taken by NewLockedVolumeDriver, leaving every volume mounted.
+// Lingering volumes stay recorded as such, so the next driver to take the
+// lock resumes their teardowns. Use Drain to unmount them as well.
+func (d *VolumeDriver) Close() error {
+	d.stopHealth()
+	d.stopTeardowns()
+	return d.releaseStateLock()
+}
+
+func lockFilePath(mountPathRoot string) string {
+	return filepath.Join(mountPathRoot, lockFileName)
+}
+
+func (d *VolumeDriver) releaseStateLock() error {
+	return d.stateLock.Release()
+}
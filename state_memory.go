@@ -0,0 +1,73 @@
+package volumedriver
+
+import (
+	"encoding/json"
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// MemoryStateStore keeps state in memory, for tests. State survives a new
+// VolumeDriver built on the same store but not the process. Volumes are
+// round-tripped through JSON, so only what a file store would persist is kept.
+type MemoryStateStore struct {
+	lock    sync.Mutex
+	volumes map[string]json.RawMessage
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{volumes: map[string]json.RawMessage{}}
+}
+
+func (s *MemoryStateStore) Load(_ lager.Logger) (map[string]NfsVolumeInfo, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	volumes := make(map[string]NfsVolumeInfo, len(s.volumes))
+	for name, data := range s.volumes {
+		var volume NfsVolumeInfo
+		if err := json.Unmarshal(data, &volume); err != nil {
+			return nil, err
+		}
+		volumes[name] = volume
+	}
+	return volumes, nil
+}
+
+func (s *MemoryStateStore) Save(_ lager.Logger, volumes map[string]NfsVolumeInfo) error {
+	encoded := make(map[string]json.RawMessage, len(volumes))
+	for name, volume := range volumes {
+		data, err := json.Marshal(volume)
+		if err != nil {
+			return err
+		}
+		encoded[name] = data
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.volumes = encoded
+	return nil
+}
+
+func (s *MemoryStateStore) PutVolume(_ lager.Logger, volume NfsVolumeInfo) error {
+	data, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.volumes[volume.Name] = data
+	return nil
+}
+
+func (s *MemoryStateStore) DeleteVolume(_ lager.Logger, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.volumes, name)
+	return nil
+}
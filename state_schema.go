@@ -0,0 +1,109 @@
+package volumedriver
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DriverVersion is recorded in every state file so that a state file can be
+// traced back to the release that wrote it. Releases override it at build time
+// with -ldflags "-X code.cloudfoundry.org/volumedriver.DriverVersion=<version>".
+var DriverVersion = "dev"
+
+// CurrentStateVersion is the state file format written by this driver.
+//
+// Version 0 is the original unversioned layout: a bare JSON object mapping
+// volume names to NfsVolumeInfo.
+const CurrentStateVersion = 1
+
+// stateMigration upgrades the volumes section of a state file by one format
+// version. Migrations are keyed by the version they upgrade from.
+type stateMigration func(volumes json.RawMessage) (json.RawMessage, error)
+
+var stateMigrations = map[int]stateMigration{
+	0: migrateStateV0ToV1,
+}
+
+type stateEnvelope struct {
+	Version       int       `json:"version"`
+	DriverVersion string    `json:"driver_version"`
+	WrittenAt     time.Time `json:"written_at"`
+	// Sequence is the last journal record included in a snapshot written by
+	// JournalStateStore. It is zero for state written by any other store.
+	Sequence uint64          `json:"sequence,omitempty"`
+	Volumes  json.RawMessage `json:"volumes"`
+}
+
+// UnsupportedStateVersionError is returned when the state file was written in
+// a format newer than this driver understands, typically after a rollback to
+// an older release.
+type UnsupportedStateVersionError struct {
+	Version       int
+	DriverVersion string
+}
+
+func (e *UnsupportedStateVersionError) Error() string {
+	return fmt.Sprintf("state file format version %d (written by driver version %q) is newer than the supported version %d; refusing to load it", e.Version, e.DriverVersion, CurrentStateVersion)
+}
+
+func encodeState(volumes map[string]NfsVolumeInfo, writtenAt time.Time, sequence uint64) ([]byte, error) {
+	volumeData, err := json.Marshal(volumes)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(stateEnvelope{
+		Version:       CurrentStateVersion,
+		DriverVersion: DriverVersion,
+		WrittenAt:     writtenAt,
+		Sequence:      sequence,
+		Volumes:       volumeData,
+	})
+}
+
+// decodeState parses a state file of any known format version, running every
+// migration needed to bring it up to CurrentStateVersion.
+func decodeState(data []byte) (map[string]NfsVolumeInfo, stateEnvelope, error) {
+	var envelope stateEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Version == 0 {
+		// Either the file predates versioning, or a legacy volume happens to be
+		// called "version"; both mean the whole document is the volume map.
+		envelope = stateEnvelope{Volumes: data}
+	}
+
+	if envelope.Version > CurrentStateVersion {
+		return nil, envelope, &UnsupportedStateVersionError{Version: envelope.Version, DriverVersion: envelope.DriverVersion}
+	}
+
+	volumeData := envelope.Volumes
+	for version := envelope.Version; version < CurrentStateVersion; version++ {
+		migrate, ok := stateMigrations[version]
+		if !ok {
+			return nil, envelope, fmt.Errorf("no migration from state file format version %d", version)
+		}
+
+		var err error
+		volumeData, err = migrate(volumeData)
+		if err != nil {
+			return nil, envelope, fmt.Errorf("migrating state file format version %d: %w", version, err)
+		}
+	}
+
+	volumes := map[string]NfsVolumeInfo{}
+	if err := json.Unmarshal(volumeData, &volumes); err != nil {
+		return nil, envelope, err
+	}
+
+	return volumes, envelope, nil
+}
+
+// migrateStateV0ToV1 moves the bare volume map into the versioned envelope.
+// The volume layout itself is unchanged, so the map is only validated.
+func migrateStateV0ToV1(volumes json.RawMessage) (json.RawMessage, error) {
+	var legacy map[string]NfsVolumeInfo
+	if err := json.Unmarshal(volumes, &legacy); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
@@ -0,0 +1,33 @@
+package volumedriver
+
+import (
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// StateStore persists the driver's volumes so that they survive a restart.
+// Volumes are handed over with their Opts already sealed into PersistedOpts;
+// a store only needs to round-trip what NfsVolumeInfo marshals to JSON.
+type StateStore interface {
+	// Load returns the persisted volumes. It returns an
+	// *UnsupportedStateVersionError for state written by a newer driver.
+	Load(logger lager.Logger) (map[string]NfsVolumeInfo, error)
+	// Save replaces all of the persisted state with volumes.
+	Save(logger lager.Logger, volumes map[string]NfsVolumeInfo) error
+}
+
+// VolumeStateStore is a StateStore that can also record a change to a single
+// volume without rewriting the others. The driver uses it, when available,
+// for changes made by Create, Mount, Unmount and Remove.
+type VolumeStateStore interface {
+	StateStore
+	PutVolume(logger lager.Logger, volume NfsVolumeInfo) error
+	DeleteVolume(logger lager.Logger, name string) error
+}
+
+// WithStateStore sets where the driver persists its state. Defaults to a
+// JSONFileStateStore writing driver-state.json under the mount path root.
+func WithStateStore(store StateStore) Option {
+	return func(d *VolumeDriver) {
+		d.stateStore = store
+	}
+}
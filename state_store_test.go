@@ -0,0 +1,289 @@
+package volumedriver_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/goshims/filepathshim"
+	"code.cloudfoundry.org/goshims/osshim"
+	"code.cloudfoundry.org/goshims/timeshim"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"code.cloudfoundry.org/volumedriver"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State stores", func() {
+	var logger *lagertest.TestLogger
+
+	volume := func(name string, count int) volumedriver.NfsVolumeInfo {
+		return volumedriver.NfsVolumeInfo{
+			VolumeInfo:    dockerdriver.VolumeInfo{Name: name, Mountpoint: "/mnt/" + name, MountCount: count},
+			Opts:          map[string]interface{}{"source": "server:/" + name},
+			PersistedOpts: map[string]interface{}{"source": "server:/" + name},
+		}
+	}
+
+	persisted := func(v volumedriver.NfsVolumeInfo) volumedriver.NfsVolumeInfo {
+		v.Opts = nil
+		return v
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("state-store")
+	})
+
+	itBehavesLikeAVolumeStateStore := func(newStore func() volumedriver.VolumeStateStore) {
+		var store volumedriver.VolumeStateStore
+
+		BeforeEach(func() {
+			store = newStore()
+		})
+
+		It("loads what was saved, without the unsealed opts", func() {
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1), "b": volume("b", 0)})).To(Succeed())
+
+			Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{
+				"a": persisted(volume("a", 1)),
+				"b": persisted(volume("b", 0)),
+			}))
+		})
+
+		It("replaces everything on save", func() {
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1)})).To(Succeed())
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"b": volume("b", 1)})).To(Succeed())
+
+			Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"b": persisted(volume("b", 1))}))
+		})
+
+		It("records changes to single volumes", func() {
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1), "b": volume("b", 1)})).To(Succeed())
+			Expect(store.PutVolume(logger, volume("a", 2))).To(Succeed())
+			Expect(store.PutVolume(logger, volume("c", 1))).To(Succeed())
+			Expect(store.DeleteVolume(logger, "b")).To(Succeed())
+
+			Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{
+				"a": persisted(volume("a", 2)),
+				"c": persisted(volume("c", 1)),
+			}))
+		})
+	}
+
+	Describe("MemoryStateStore", func() {
+		itBehavesLikeAVolumeStateStore(func() volumedriver.VolumeStateStore {
+			return volumedriver.NewMemoryStateStore()
+		})
+
+		It("starts out empty", func() {
+			Expect(volumedriver.NewMemoryStateStore().Load(logger)).To(BeEmpty())
+		})
+	})
+
+	Describe("JournalStateStore", func() {
+		var (
+			dir     string
+			journal string
+			config  volumedriver.JournalConfig
+		)
+
+		newStore := func() *volumedriver.JournalStateStore {
+			return volumedriver.NewJournalStateStore(&osshim.OsShim{}, &filepathshim.FilepathShim{}, &timeshim.TimeShim{}, dir, config)
+		}
+
+		journalRecords := func() []string {
+			data, err := os.ReadFile(journal)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return strings.Split(strings.TrimSpace(string(data)), "\n")
+		}
+
+		BeforeEach(func() {
+			dir = filepath.Join(GinkgoT().TempDir(), "state")
+			journal = filepath.Join(dir, "driver-state.journal")
+			config = volumedriver.JournalConfig{}
+		})
+
+		itBehavesLikeAVolumeStateStore(func() volumedriver.VolumeStateStore { return newStore() })
+
+		It("appends a small record for each change", func() {
+			store := newStore()
+			Expect(store.PutVolume(logger, volume("a", 0))).To(Succeed())
+			Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+			Expect(store.PutVolume(logger, volume("a", 2))).To(Succeed())
+			Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+			Expect(store.DeleteVolume(logger, "a")).To(Succeed())
+
+			records := journalRecords()
+			Expect(records).To(HaveLen(5))
+			Expect(records[0]).To(MatchJSON(`{"version":1,"seq":1,"op":"create","name":"a","volume":{"Name":"a","Mountpoint":"/mnt/a","MountCount":0,"PersistedOpts":{"source":"server:/a"}}}`))
+			Expect(records[1]).To(MatchJSON(`{"version":1,"seq":2,"op":"incref","name":"a","mountpoint":"/mnt/a"}`))
+			Expect(records[2]).To(MatchJSON(`{"version":1,"seq":3,"op":"incref","name":"a","mountpoint":"/mnt/a"}`))
+			Expect(records[3]).To(MatchJSON(`{"version":1,"seq":4,"op":"decref","name":"a"}`))
+			Expect(records[4]).To(MatchJSON(`{"version":1,"seq":5,"op":"delete","name":"a"}`))
+		})
+
+		It("records any other change as an update", func() {
+			store := newStore()
+			Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+
+			degraded := volume("a", 1)
+			degraded.Degraded = true
+			Expect(store.PutVolume(logger, degraded)).To(Succeed())
+
+			Expect(journalRecords()[1]).To(ContainSubstring(`"op":"update"`))
+			Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(degraded)}))
+		})
+
+		It("fails to load when there is neither a snapshot nor a journal", func() {
+			_, err := newStore().Load(logger)
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+
+		Context("when the journal reaches the record threshold", func() {
+			BeforeEach(func() {
+				config.MaxRecords = 3
+			})
+
+			It("compacts it into a snapshot", func() {
+				store := newStore()
+				Expect(store.PutVolume(logger, volume("a", 0))).To(Succeed())
+				Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+				Expect(journalRecords()).To(HaveLen(2))
+
+				Expect(store.PutVolume(logger, volume("b", 0))).To(Succeed())
+				Expect(journalRecords()).To(BeEmpty())
+				Expect(filepath.Join(dir, "driver-state.json")).To(BeARegularFile())
+
+				Expect(store.PutVolume(logger, volume("b", 1))).To(Succeed())
+				Expect(journalRecords()).To(HaveLen(1))
+				Expect(journalRecords()[0]).To(ContainSubstring(`"seq":4`))
+
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{
+					"a": persisted(volume("a", 1)),
+					"b": persisted(volume("b", 1)),
+				}))
+			})
+		})
+
+		Context("when the journal reaches the size threshold", func() {
+			BeforeEach(func() {
+				config.MaxBytes = 100
+			})
+
+			It("compacts it into a snapshot", func() {
+				store := newStore()
+				Expect(store.PutVolume(logger, volume("a", 0))).To(Succeed())
+				Expect(journalRecords()).To(BeEmpty())
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 0))}))
+			})
+		})
+
+		Context("when the driver stopped between writing a snapshot and truncating the journal", func() {
+			It("does not replay the records already in the snapshot", func() {
+				store := newStore()
+				Expect(store.PutVolume(logger, volume("a", 0))).To(Succeed())
+				Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+				stale, err := os.ReadFile(journal)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1)})).To(Succeed())
+				Expect(os.WriteFile(journal, stale, 0600)).To(Succeed())
+
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 1))}))
+			})
+		})
+
+		Context("when the last record was torn by a crash", func() {
+			BeforeEach(func() {
+				store := newStore()
+				Expect(store.PutVolume(logger, volume("a", 0))).To(Succeed())
+				appendToFile(journal, `{"version":1,"seq":2,"op":"incr`)
+			})
+
+			It("ignores it", func() {
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 0))}))
+			})
+
+			It("does not append after it", func() {
+				store := newStore()
+				_, err := store.Load(logger)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(store.PutVolume(logger, volume("a", 1))).To(Succeed())
+
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 1))}))
+			})
+		})
+
+		Context("when an earlier record is corrupt", func() {
+			It("refuses to load", func() {
+				Expect(os.MkdirAll(dir, 0700)).To(Succeed())
+				Expect(os.WriteFile(journal, []byte("garbage\n"+`{"version":1,"seq":1,"op":"delete","name":"a"}`+"\n"), 0600)).To(Succeed())
+
+				_, err := newStore().Load(logger)
+				Expect(err).To(MatchError(ContainSubstring("corrupt journal record at line 1")))
+			})
+		})
+
+		Context("when a record was written by a newer driver", func() {
+			It("refuses to load", func() {
+				Expect(os.MkdirAll(dir, 0700)).To(Succeed())
+				Expect(os.WriteFile(journal, []byte(`{"version":99,"seq":1,"op":"delete","name":"a"}`+"\n"), 0600)).To(Succeed())
+
+				_, err := newStore().Load(logger)
+				var unsupported *volumedriver.UnsupportedStateVersionError
+				Expect(err).To(BeAssignableToTypeOf(unsupported))
+			})
+		})
+
+		Context("when the state was written by a JSONFileStateStore", func() {
+			It("uses it as the snapshot", func() {
+				jsonStore := volumedriver.NewJSONFileStateStore(&osshim.OsShim{}, &filepathshim.FilepathShim{}, &timeshim.TimeShim{}, dir)
+				Expect(jsonStore.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1)})).To(Succeed())
+
+				store := newStore()
+				Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 1))}))
+				Expect(store.PutVolume(logger, volume("a", 2))).To(Succeed())
+				Expect(newStore().Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 2))}))
+			})
+		})
+	})
+
+	Describe("JSONFileStateStore", func() {
+		var (
+			dir   string
+			store *volumedriver.JSONFileStateStore
+		)
+
+		BeforeEach(func() {
+			dir = filepath.Join(GinkgoT().TempDir(), "state")
+			store = volumedriver.NewJSONFileStateStore(&osshim.OsShim{}, &filepathshim.FilepathShim{}, &timeshim.TimeShim{}, dir)
+		})
+
+		It("creates its directory and loads what was saved", func() {
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1)})).To(Succeed())
+
+			Expect(filepath.Join(dir, "driver-state.json")).To(BeARegularFile())
+			Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 1))}))
+		})
+
+		It("keeps the previous generation as a backup", func() {
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"a": volume("a", 1)})).To(Succeed())
+			Expect(store.Save(logger, map[string]volumedriver.NfsVolumeInfo{"b": volume("b", 1)})).To(Succeed())
+			Expect(os.Remove(filepath.Join(dir, "driver-state.json"))).To(Succeed())
+
+			Expect(store.Load(logger)).To(Equal(map[string]volumedriver.NfsVolumeInfo{"a": persisted(volume("a", 1))}))
+		})
+	})
+})
+
+func appendToFile(path, data string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	Expect(err).NotTo(HaveOccurred())
+	defer f.Close()
+	_, err = f.WriteString(data)
+	Expect(err).NotTo(HaveOccurred())
+}
@@ -0,0 +1,169 @@
+package volumedriver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+)
+
+// SweepMode is what NewVolumeDriver does with mounts and directories under
+// the mount root that no volume refers to, e.g. after a crash part way
+// through a Mount or Unmount. If the persisted state could not be restored,
+// every live mount looks orphaned, so the sweep is only ever a dry run.
+type SweepMode string
+
+const (
+	// SweepRemove unmounts orphaned mounts and removes orphaned directories.
+	SweepRemove SweepMode = "remove"
+	// SweepDryRun reports the orphans without touching them. It is the
+	// default.
+	SweepDryRun SweepMode = "dry-run"
+	// SweepDisabled does not look for orphans.
+	SweepDisabled SweepMode = "disabled"
+)
+
+// SweepAction is what the sweeper did, or in a dry run would do, with an
+// orphan.
+type SweepAction string
+
+const (
+	// SweepUnmount unmounts an orphaned mount and removes its directory.
+	SweepUnmount SweepAction = "unmount"
+	// SweepRemoveDirectory removes an orphaned directory that is not mounted.
+	SweepRemoveDirectory SweepAction = "remove-directory"
+)
+
+// SweepReport describes the orphans found under the mount root and what was
+// done with them.
+type SweepReport struct {
+	DryRun  bool
+	Orphans []SweepEntry
+	// Error is set when the mounts or directories could not be listed. Nothing
+	// is removed in that case.
+	Error string `json:",omitempty"`
+}
+
+type SweepEntry struct {
+	Path    string
+	Mounted bool
+	Action  SweepAction
+	Error   string `json:",omitempty"`
+}
+
+// SweepReport returns the result of the sweep performed when the driver was
+// constructed.
+func (d *VolumeDriver) SweepReport() SweepReport {
+	return d.sweepReport
+}
+
+// SweepOrphans unmounts every mount, and removes every directory, directly
+// under the mount root that does not belong to a known volume. With dryRun
+// it only reports them. Directories are never removed while they are still
+// mounted, and only if they are empty.
+func (d *VolumeDriver) SweepOrphans(env dockerdriver.Env, dryRun bool) SweepReport {
+	logger := env.Logger().Session("sweep-orphans", lager.Data{"dry-run": dryRun})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	report := SweepReport{DryRun: dryRun, Orphans: []SweepEntry{}}
+
+	root, err := d.filepath.Abs(d.mountPathRoot)
+	if err != nil {
+		logger.Error("abs-failed", err)
+		report.Error = err.Error()
+		return report
+	}
+
+	mounts, err := d.listMounts("^" + regexp.QuoteMeta(root+string(filepath.Separator)) + `[^/\\]+$`)
+	if err != nil {
+		// Without the mount table, a directory cannot be known to be unmounted.
+		logger.Error("failed-proc-mounts-list", err)
+		report.Error = err.Error()
+		return report
+	}
+	mounted := map[string]bool{}
+	for _, mount := range mounts {
+		mounted[filepath.Base(mount)] = true
+	}
+
+	entries, err := d.os.ReadDir(root)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error("read-mount-root-failed", err)
+		report.Error = err.Error()
+		return report
+	}
+	names := map[string]bool{}
+	for name := range mounted {
+		names[name] = true
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names[entry.Name()] = true
+		}
+	}
+
+	candidates := make([]string, 0, len(names))
+	for name := range names {
+		candidates = append(candidates, name)
+	}
+	sort.Strings(candidates)
+
+	for _, name := range candidates {
+		entry, orphaned := d.sweepOrphan(env, logger, name, filepath.Join(root, name), mounted[name], dryRun)
+		if orphaned {
+			report.Orphans = append(report.Orphans, entry)
+		}
+	}
+
+	logger.Info("report", lager.Data{"report": report})
+	return report
+}
+
+func (d *VolumeDriver) sweepOrphan(env dockerdriver.Env, logger lager.Logger, name, path string, mounted, dryRun bool) (SweepEntry, bool) {
+	// Hold the volume's lock so that a volume being created and mounted at
+	// the same time is not mistaken for an orphan.
+	unlock := d.volumeLocks.Lock(name)
+	defer unlock()
+
+	if _, ok := d.volumes.Get(name); ok {
+		return SweepEntry{}, false
+	}
+
+	entry := SweepEntry{Path: path, Mounted: mounted, Action: SweepRemoveDirectory}
+	if mounted {
+		entry.Action = SweepUnmount
+	}
+	if dryRun {
+		logger.Info("found-orphan", lager.Data{"entry": entry})
+		return entry, true
+	}
+
+	var err error
+	switch {
+	case mounted:
+		_, err = d.unmountVolume(env, name, path)
+		var notMounted *drivererrors.NotMountedError
+		if errors.As(err, &notMounted) {
+			// It went away by itself, and its directory is already gone.
+			err = nil
+		}
+	case d.stillMounted(logger, path):
+		err = errors.New("mounted since the mount table was listed")
+	default:
+		err = d.os.Remove(path)
+	}
+	if err != nil {
+		err = d.redactor.Error(err)
+		logger.Error("sweep-orphan-failed", err, lager.Data{"entry": entry})
+		entry.Error = err.Error()
+	} else {
+		logger.Info("swept-orphan", lager.Data{"entry": entry})
+	}
+	return entry, true
+}
@@ -0,0 +1,10 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package volumedriver
+
+import "regexp"
+
+func (d *VolumeDriver) listMounts(pattern string) ([]string, error) {
+	return d.mountChecker.List(regexp.MustCompile(pattern))
+}
@@ -0,0 +1,8 @@
+//go:build windows
+// +build windows
+
+package volumedriver
+
+func (d *VolumeDriver) listMounts(pattern string) ([]string, error) {
+	return d.mountChecker.List(pattern)
+}
@@ -0,0 +1,117 @@
+package volumedriver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/dockerdriver/driverhttp"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// UnmountPolicy decides how hard the driver tries to unmount a mount that is
+// busy or whose server has gone away. After a normal unmount fails, the
+// driver forces the unmount and then detaches it lazily, if the policy allows
+// and the Mounter is a FlagUnmounter.
+type UnmountPolicy struct {
+	// Timeout bounds each step. A forced or lazy step gets its own timeout
+	// even if the request's deadline has already passed.
+	Timeout time.Duration
+	Force   bool
+	Lazy    bool
+}
+
+// DefaultUnmountPolicy escalates through every step.
+var DefaultUnmountPolicy = UnmountPolicy{
+	Timeout: 10 * time.Second,
+	Force:   true,
+	Lazy:    true,
+}
+
+// WithUnmountPolicy sets how the driver escalates a failed unmount. Defaults
+// to DefaultUnmountPolicy.
+func WithUnmountPolicy(policy UnmountPolicy) Option {
+	return func(d *VolumeDriver) {
+		d.unmountPolicy = policy
+	}
+}
+
+var errFlagUnmountUnsupported = errors.New("mounter does not support forced unmounts")
+
+// unmountNormally calls the Mounter, bounded by the policy's timeout.
+func (d *VolumeDriver) unmountNormally(env dockerdriver.Env, mountPath string) error {
+	if d.unmountPolicy.Timeout <= 0 {
+		return d.mounter.Unmount(env, mountPath)
+	}
+
+	ctx, cancel := context.WithTimeout(env.Context(), d.unmountPolicy.Timeout)
+	defer cancel()
+	return d.mounter.Unmount(driverhttp.EnvWithContext(ctx, env), mountPath)
+}
+
+// escalateUnmount forces, then lazily detaches, a mount that a normal
+// unmount failed to remove. A step only counts once the MountChecker no
+// longer finds the mount.
+func (d *VolumeDriver) escalateUnmount(env dockerdriver.Env, name, mountPath string) error {
+	flagUnmounter, ok := d.mounter.(FlagUnmounter)
+	if !ok {
+		return errFlagUnmountUnsupported
+	}
+
+	var steps []UnmountFlags
+	if d.unmountPolicy.Force {
+		steps = append(steps, UnmountFlags{Force: true, Timeout: d.unmountPolicy.Timeout})
+	}
+	if d.unmountPolicy.Lazy {
+		steps = append(steps, UnmountFlags{Lazy: true, Timeout: d.unmountPolicy.Timeout})
+	}
+	if len(steps) == 0 {
+		return errFlagUnmountUnsupported
+	}
+
+	logger := env.Logger().Session("escalate-unmount", lager.Data{"volume": name, "mountpoint": mountPath})
+
+	var err error
+	for _, flags := range steps {
+		data := lager.Data{"force": flags.Force, "lazy": flags.Lazy}
+		logger.Info("unmounting-with-flags", data)
+
+		err = d.unmountWithFlags(env, flagUnmounter, mountPath, flags)
+		if err == nil && d.stillMounted(logger, mountPath) {
+			err = errors.New("still mounted")
+		}
+		if err != nil {
+			logger.Error("unmount-with-flags-failed", d.redactor.Error(err), data)
+			continue
+		}
+
+		logger.Info("unmounted-with-flags", data)
+		return nil
+	}
+	return err
+}
+
+func (d *VolumeDriver) unmountWithFlags(env dockerdriver.Env, flagUnmounter FlagUnmounter, mountPath string, flags UnmountFlags) error {
+	// The step must be able to run after the request's deadline, as that is
+	// when a straggling mount is most likely to need it.
+	ctx := context.WithoutCancel(env.Context())
+	if flags.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.Timeout)
+		defer cancel()
+	}
+	return flagUnmounter.UnmountWithFlags(driverhttp.EnvWithContext(ctx, env), mountPath, flags)
+}
+
+// stillMounted reports whether mountPath is still in the mount table. When
+// the mount table cannot be read the mount is assumed to be there, so that
+// its directory is left alone.
+func (d *VolumeDriver) stillMounted(logger lager.Logger, mountPath string) bool {
+	exists, err := d.mountChecker.Exists(mountPath)
+	if err != nil {
+		logger.Error("failed-proc-mounts-check", err, lager.Data{"mountpoint": mountPath})
+		return true
+	}
+	return exists
+}
@@ -0,0 +1,110 @@
+package volumedriver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/dockerdriver/driverhttp"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+	"code.cloudfoundry.org/volumedriver/internal/syncmap"
+)
+
+// WithUnmountLinger keeps a volume mounted for delay after its last Unmount,
+// so that a Mount within that window, e.g. by an app that is restarting,
+// reuses the live mount instead of mounting again. Pending teardowns are
+// persisted, resumed when the driver restarts, and flushed by Drain.
+// Defaults to 0, which tears the mount down straight away.
+func WithUnmountLinger(delay time.Duration) Option {
+	return func(d *VolumeDriver) {
+		d.unmountLinger = delay
+	}
+}
+
+func newLingerTimers() *syncmap.SyncMap[Timer] {
+	return syncmap.New[Timer]()
+}
+
+// lingering reports whether a volume's mount is being kept after its last
+// Unmount.
+func lingering(volume NfsVolumeInfo) bool {
+	return volume.MountCount < 1 && volume.TeardownAt != nil
+}
+
+// scheduleTeardown tears the volume's mount down at the given time, unless
+// it is mounted again first.
+func (d *VolumeDriver) scheduleTeardown(logger lager.Logger, name string, at time.Time) {
+	delay := at.Sub(d.clock.Now())
+	if delay < 0 {
+		delay = 0
+	}
+	logger.Info("scheduled-teardown", lager.Data{"volume": name, "teardown-at": at, "delay": delay.String()})
+
+	timer := d.clock.AfterFunc(delay, func() {
+		d.teardownLingering(logger, name, at)
+	})
+	if previous, ok := d.lingerTimers.Get(name); ok {
+		previous.Stop()
+	}
+	d.lingerTimers.Put(name, timer)
+}
+
+// cancelTeardown stops the volume's scheduled teardown, if it has one.
+func (d *VolumeDriver) cancelTeardown(name string) {
+	if timer, ok := d.lingerTimers.Get(name); ok {
+		timer.Stop()
+		d.lingerTimers.Delete(name)
+	}
+}
+
+// resumeTeardowns schedules the teardowns recorded in restored state.
+func (d *VolumeDriver) resumeTeardowns(logger lager.Logger) {
+	for _, volume := range d.volumes.Values() {
+		if lingering(volume) {
+			d.scheduleTeardown(logger, volume.Name, *volume.TeardownAt)
+		}
+	}
+}
+
+// stopTeardowns cancels every scheduled teardown. The volumes stay lingering.
+func (d *VolumeDriver) stopTeardowns() {
+	for _, name := range d.lingerTimers.Keys() {
+		d.cancelTeardown(name)
+	}
+}
+
+// teardownLingering unmounts and forgets a volume whose linger window,
+// ending at the given time, has passed. It does nothing if the volume was
+// mounted again, or has been removed, since the teardown was scheduled.
+func (d *VolumeDriver) teardownLingering(logger lager.Logger, name string, at time.Time) {
+	logger = logger.Session("teardown-lingering", lager.Data{"volume": name})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	unlock := d.volumeLocks.Lock(name)
+	defer unlock()
+
+	volume, ok := d.volumes.Get(name)
+	if !ok || !lingering(volume) || !volume.TeardownAt.Equal(at) {
+		logger.Info("teardown-cancelled")
+		return
+	}
+	d.lingerTimers.Delete(name)
+
+	env := driverhttp.NewHttpDriverEnv(logger, context.Background())
+	if err := d.unmount(env, name, volume.Mountpoint); err != nil {
+		var notMounted *drivererrors.NotMountedError
+		if !errors.As(err, &notMounted) {
+			// Forget the volume all the same, as the last Unmount would have.
+			logger.Error("teardown-failed", d.redactor.Error(err))
+		}
+	}
+
+	d.volumes.Delete(name)
+	d.mountRecords.Delete(name)
+	if err := d.persistVolume(env, name); err != nil {
+		logger.Error("persist-state-failed", err)
+	}
+	d.recordVolumeGauges()
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"code.cloudfoundry.org/dockerdriver"
@@ -16,8 +17,15 @@ import (
 	"code.cloudfoundry.org/goshims/osshim"
 	"code.cloudfoundry.org/goshims/timeshim"
 	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/volumedriver/drivererrors"
+	"code.cloudfoundry.org/volumedriver/internal/keylock"
+	"code.cloudfoundry.org/volumedriver/internal/sealer"
+	"code.cloudfoundry.org/volumedriver/internal/statelock"
 	"code.cloudfoundry.org/volumedriver/internal/syncmap"
+	"code.cloudfoundry.org/volumedriver/metrics"
 	"code.cloudfoundry.org/volumedriver/mountchecker"
+	"code.cloudfoundry.org/volumedriver/mountclassifier"
+	"code.cloudfoundry.org/volumedriver/redactor"
 )
 
 // MountPointNotExistError indicates that the mount point does not exist.
@@ -53,97 +61,218 @@ func (d *VolumeDriver) removeMountPath(logger lager.Logger, name, mountPath stri
 }
 
 type NfsVolumeInfo struct {
-	Opts                    map[string]interface{} `json:"-"` // don't store opts
+	Opts                    map[string]interface{} `json:"-"` // persisted through PersistedOpts
 	dockerdriver.VolumeInfo                        // see dockerdriver.resources.go
+	// PersistedOpts is the copy of Opts written to the state file, with the
+	// values of sensitive keys sealed or left out. See WithOptsEncryptionKey.
+	PersistedOpts map[string]interface{} `json:",omitempty"`
+	// Degraded is set when the volume is recorded as mounted but its kernel
+	// mount has gone missing. The next Mount remounts it and clears the flag.
+	Degraded bool `json:",omitempty"`
+	// MountIDs are the callers holding a reference, for those that sent an
+	// ID. MountCount also counts callers that did not. See WithCallerID.
+	MountIDs []string `json:",omitempty"`
+	// TeardownAt is set while the volume's mount is kept after its last
+	// Unmount, and is when it will be torn down. See WithUnmountLinger.
+	TeardownAt *time.Time `json:",omitempty"`
 }
 
+// OsHelper is no longer used by the driver.
+//
+// Deprecated: the driver sets the mode of what it creates explicitly, rather
+// than changing the process-wide umask. NewVolumeDriver accepts nil.
 type OsHelper interface {
 	Umask(mask int) (oldmask int)
 }
 
 type VolumeDriver struct {
-	volumes       *syncmap.SyncMap[NfsVolumeInfo]
-	os            osshim.Os
-	filepath      filepathshim.Filepath
-	time          timeshim.Time
-	mountChecker  mountchecker.MountChecker
-	mountPathRoot string
-	mounter       Mounter
-	osHelper      OsHelper
+	volumes          *syncmap.SyncMap[NfsVolumeInfo]
+	volumeLocks      *keylock.KeyLock
+	mountFlights     *mountFlights
+	persistLock      sync.Mutex
+	os               osshim.Os
+	filepath         filepathshim.Filepath
+	time             timeshim.Time
+	clock            Clock
+	mountChecker     mountchecker.MountChecker
+	mountPathRoot    string
+	mounter          Mounter
+	redactor         *redactor.Redactor
+	metrics          metrics.Recorder
+	retryPolicy      RetryPolicy
+	drainConcurrency int
+	unmountPolicy    UnmountPolicy
+	stateStore       StateStore
+	stateLock        *statelock.Lock
+	permissions      Permissions
+
+	// stateRefused is set when the persisted state was written by a newer
+	// driver. Nothing is saved over it.
+	stateRefused error
+
+	mountTimeout         time.Duration
+	mountDurationWarning time.Duration
+	unmountLinger        time.Duration
+	lingerTimers         *syncmap.SyncMap[Timer]
+
+	reconcileAction      ReconcileAction
+	reconciliationReport ReconciliationReport
+	sweepMode            SweepMode
+	sweepReport          SweepReport
+
+	optsEncryptionKey []byte
+	optsSealer        *sealer.Sealer
+
+	healthConfig      *HealthMonitorConfig
+	health            *syncmap.SyncMap[VolumeHealth]
+	mountRecords      *syncmap.SyncMap[mountRecord]
+	stopHealthMonitor context.CancelFunc
+	healthMonitorDone chan struct{}
 }
 
-func NewVolumeDriver(logger lager.Logger, os osshim.Os, filepath filepathshim.Filepath, time timeshim.Time, mountChecker mountchecker.MountChecker, mountPathRoot string, mounter Mounter, oshelper OsHelper) *VolumeDriver {
+func NewVolumeDriver(logger lager.Logger, os osshim.Os, filepath filepathshim.Filepath, time timeshim.Time, mountChecker mountchecker.MountChecker, mountPathRoot string, mounter Mounter, oshelper OsHelper, opts ...Option) *VolumeDriver {
 	d := &VolumeDriver{
-		volumes:       syncmap.New[NfsVolumeInfo](),
-		os:            os,
-		filepath:      filepath,
-		time:          time,
-		mountChecker:  mountChecker,
-		mountPathRoot: mountPathRoot,
-		mounter:       mounter,
-		osHelper:      oshelper,
+		volumes:          syncmap.New[NfsVolumeInfo](),
+		volumeLocks:      keylock.New(),
+		health:           syncmap.New[VolumeHealth](),
+		mountRecords:     syncmap.New[mountRecord](),
+		mountFlights:     newMountFlights(),
+		lingerTimers:     newLingerTimers(),
+		os:               os,
+		filepath:         filepath,
+		time:             time,
+		clock:            newClock(time),
+		mountChecker:     mountChecker,
+		mountPathRoot:    mountPathRoot,
+		mounter:          mounter,
+		redactor:         redactor.Default(),
+		metrics:          metrics.Discard,
+		retryPolicy:      DefaultRetryPolicy,
+		drainConcurrency: DefaultDrainConcurrency,
+		unmountPolicy:    DefaultUnmountPolicy,
+		permissions:      DefaultPermissions,
+
+		mountDurationWarning: DefaultMountDurationWarning,
+
+		reconcileAction: ReconcileResetCounts,
+		sweepMode:       SweepDryRun,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.stateStore == nil {
+		d.stateStore = NewJSONFileStateStore(os, filepath, time, mountPathRoot)
+	}
+	if store, ok := d.stateStore.(fileStateStore); ok {
+		store.setPermissions(d.permissions.StateFile, d.permissions.MountRoot)
+	}
+
+	if d.optsEncryptionKey != nil {
+		s, err := sealer.New(d.optsEncryptionKey)
+		if err != nil {
+			logger.Error("invalid-opts-encryption-key", err)
+		}
+		d.optsSealer = s
 	}
 
 	ctx := context.TODO()
 	env := driverhttp.NewHttpDriverEnv(logger, ctx)
 
-	d.restoreState(env)
+	d.checkPermissions(logger)
+	restoreErr := d.restoreState(env)
+	d.reconciliationReport = d.reconcileState(env)
+	d.resumeTeardowns(logger)
+	if d.sweepMode != SweepDisabled {
+		dryRun := d.sweepMode == SweepDryRun
+		if restoreErr != nil && !dryRun {
+			// Without the state every live mount would look orphaned.
+			logger.Info("sweeping-dry-run-after-failed-restore", lager.Data{"err": restoreErr.Error()})
+			dryRun = true
+		}
+		d.sweepReport = d.SweepOrphans(env, dryRun)
+	}
+	d.recordVolumeGauges()
+
+	if d.healthConfig != nil {
+		d.startHealthMonitor(logger)
+	}
 
 	return d
 }
 
-func (d *VolumeDriver) Activate(env dockerdriver.Env) dockerdriver.ActivateResponse {
+func (d *VolumeDriver) Activate(env dockerdriver.Env) (response dockerdriver.ActivateResponse) {
+	defer d.observeOperation("activate", d.time.Now(), &response.Err)
+
 	return dockerdriver.ActivateResponse{
 		Implements: []string{"VolumeDriver"},
 	}
 }
 
-func (d *VolumeDriver) Create(env dockerdriver.Env, createRequest dockerdriver.CreateRequest) dockerdriver.ErrorResponse {
+func (d *VolumeDriver) Create(env dockerdriver.Env, createRequest dockerdriver.CreateRequest) (response dockerdriver.ErrorResponse) {
+	defer d.observeOperation("create", d.time.Now(), &response.Err)
+
 	logger := env.Logger().Session("create")
 	logger.Info("start")
 	defer logger.Info("end")
 
 	if createRequest.Name == "" {
-		return dockerdriver.ErrorResponse{Err: "Missing mandatory 'volume_name'"}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidNameError{})}
 	}
 	if err := validateVolumeName(createRequest.Name); err != nil {
-		return dockerdriver.ErrorResponse{Err: err.Error()}
+		return dockerdriver.ErrorResponse{Err: d.errorText(err)}
 	}
 
 	var ok bool
 	if _, ok = createRequest.Opts["source"].(string); !ok {
 		logger.Info("mount-config-missing-source", lager.Data{"volume_name": createRequest.Name})
-		return dockerdriver.ErrorResponse{Err: `Missing mandatory 'source' field in 'Opts'`}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidOptionsError{Volume: createRequest.Name, Reason: "Missing mandatory 'source' field in 'Opts'"})}
+	}
+	if _, err := d.retryPolicyFor(createRequest.Opts); err != nil {
+		logger.Info("invalid-retry-policy", lager.Data{"volume_name": createRequest.Name, "error": err.Error()})
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidOptionsError{Volume: createRequest.Name, Reason: err.Error()})}
+	}
+	if _, err := d.mountTimeoutFor(createRequest.Opts); err != nil {
+		logger.Info("invalid-mount-timeout", lager.Data{"volume_name": createRequest.Name, "error": err.Error()})
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidOptionsError{Volume: createRequest.Name, Reason: err.Error()})}
 	}
 
+	unlock := d.volumeLocks.Lock(createRequest.Name)
+	defer unlock()
+
 	existing, err := d.getVolume(driverhttp.EnvWithLogger(logger, env), createRequest.Name)
 
 	if err != nil {
 		logger.Info("creating-volume", lager.Data{"volume_name": createRequest.Name})
-		logger.Info("with-opts", lager.Data{"opts": createRequest.Opts})
+		logger.Info("with-opts", lager.Data{"opts": d.redactor.Opts(createRequest.Opts)})
 
 		volInfo := NfsVolumeInfo{
-			VolumeInfo: dockerdriver.VolumeInfo{Name: createRequest.Name},
-			Opts:       createRequest.Opts,
+			VolumeInfo:    dockerdriver.VolumeInfo{Name: createRequest.Name},
+			Opts:          createRequest.Opts,
+			PersistedOpts: d.sealOpts(logger, createRequest.Opts),
 		}
 
 		d.volumes.Put(createRequest.Name, volInfo)
 	} else {
 		existing.Opts = createRequest.Opts
+		existing.PersistedOpts = d.sealOpts(logger, createRequest.Opts)
 
 		d.volumes.Put(createRequest.Name, existing)
 	}
 
-	err = d.persistState(driverhttp.EnvWithLogger(logger, env))
+	err = d.persistVolume(driverhttp.EnvWithLogger(logger, env), createRequest.Name)
 	if err != nil {
 		logger.Error("persist-state-failed", err)
-		return dockerdriver.ErrorResponse{Err: fmt.Sprintf("persist state failed when creating: %s", err.Error())}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.StatePersistenceError{Volume: createRequest.Name, Operation: "creating", Err: err})}
 	}
 
 	return dockerdriver.ErrorResponse{}
 }
 
-func (d *VolumeDriver) List(_ dockerdriver.Env) dockerdriver.ListResponse {
+func (d *VolumeDriver) List(_ dockerdriver.Env) (response dockerdriver.ListResponse) {
+	defer d.observeOperation("list", d.time.Now(), &response.Err)
+
 	listResponse := dockerdriver.ListResponse{
 		Volumes: []dockerdriver.VolumeInfo{},
 	}
@@ -155,155 +284,239 @@ func (d *VolumeDriver) List(_ dockerdriver.Env) dockerdriver.ListResponse {
 	return listResponse
 }
 
-func (d *VolumeDriver) Mount(env dockerdriver.Env, mountRequest dockerdriver.MountRequest) dockerdriver.MountResponse {
+func (d *VolumeDriver) Mount(env dockerdriver.Env, mountRequest dockerdriver.MountRequest) (response dockerdriver.MountResponse) {
+	defer d.observeOperation("mount", d.time.Now(), &response.Err)
+
 	logger := env.Logger().Session("mount", lager.Data{"volume": mountRequest.Name})
 	logger.Info("start")
 	defer logger.Info("end")
 
 	if mountRequest.Name == "" {
-		return dockerdriver.MountResponse{Err: "Missing mandatory 'volume_name'"}
+		return dockerdriver.MountResponse{Err: d.errorText(&drivererrors.InvalidNameError{})}
 	}
 	if err := validateVolumeName(mountRequest.Name); err != nil {
-		return dockerdriver.MountResponse{Err: err.Error()}
+		return dockerdriver.MountResponse{Err: d.errorText(err)}
+	}
+
+	// Concurrent requests share one mount, or one Check of the existing mount.
+	flight, leader := d.mountFlights.join(mountRequest.Name, CallerID(env.Context()))
+	if !leader {
+		return d.awaitMountFlight(env, logger, mountRequest.Name, flight)
 	}
+	defer func() {
+		d.mountFlights.close(mountRequest.Name, flight)
+		flight.response = response
+		close(flight.done)
+	}()
+
+	unlock := d.volumeLocks.Lock(mountRequest.Name)
+	defer unlock()
 
-	volume, ok := d.volumes.Get(mountRequest.Name)
+	previous, ok := d.volumes.Get(mountRequest.Name)
 	if !ok {
-		return dockerdriver.MountResponse{Err: fmt.Sprintf("Volume '%s' must be created before being mounted", mountRequest.Name)}
+		return dockerdriver.MountResponse{Err: d.errorText(&drivererrors.NotFoundError{Volume: mountRequest.Name})}
 	}
+	volume := previous
 
 	mountPath := d.mountPath(driverhttp.EnvWithLogger(logger, env), volume.Name)
 	volume.Mountpoint = mountPath
 	logger.Info("mounting-volume", lager.Data{"id": volume.Name, "mountpoint": mountPath})
-	logger.Info("mount-source", lager.Data{"source": volume.Opts["source"].(string)})
-
-	doMount := volume.MountCount < 1
-	volume.MountCount++
-	logger.Info("volume-ref-count-incremented", lager.Data{"name": volume.Name, "count": volume.MountCount})
-
-	d.volumes.Put(mountRequest.Name, volume)
-	if err := d.persistState(driverhttp.EnvWithLogger(logger, env)); err != nil {
-		logger.Error("persist-state-failed", err)
-		return dockerdriver.MountResponse{Err: fmt.Sprintf("persist state failed when mounting: %s", err.Error())}
-	}
+	source, _ := volume.Opts["source"].(string)
+	logger.Info("mount-source", lager.Data{"source": d.redactor.String(source)})
 
+	// The kernel mount, the refcount and the persisted state form a single
+	// transaction: the mount happens first, and the refcount is only committed
+	// once it has been persisted. Any failure leaves all three as they were.
+	doMount := volume.MountCount < 1 && !lingering(volume)
 	if doMount {
 		mountStartTime := d.time.Now()
 
 		err := d.mount(driverhttp.EnvWithLogger(logger, env), copyOpts(volume.Opts), mountPath)
 
 		mountEndTime := d.time.Now()
-		mountDuration := mountEndTime.Sub(mountStartTime)
-		if mountDuration > 8*time.Second {
-			logger.Error("mount-duration-too-high", nil, lager.Data{"mount-duration-in-second": mountDuration / time.Second, "warning": "This may result in container creation failure!"})
-		}
+		d.recordMount(volume.Name, mountStartTime, mountEndTime, err)
+		d.warnIfMountSlow(logger, mountEndTime.Sub(mountStartTime))
 
-		switch err.(type) {
-		case nil:
-			return dockerdriver.MountResponse{Mountpoint: volume.Mountpoint}
-		case dockerdriver.SafeError:
-			errBytes, mErr := json.Marshal(err)
-			if mErr != nil {
-				logger.Error("failed-to-marshal-safeerror", mErr)
-				return dockerdriver.MountResponse{Err: err.Error()}
-			}
-			return dockerdriver.MountResponse{Err: string(errBytes)}
-		default:
-			return dockerdriver.MountResponse{Err: err.Error()}
+		if err != nil {
+			return dockerdriver.MountResponse{Err: d.errorText(d.mountError(env, volume.Name, mountPath, err))}
 		}
 	} else {
 		// Check the volume to make sure it's still mounted before handing it out again.
-		if !d.mounter.Check(driverhttp.EnvWithLogger(logger, env), volume.Name, volume.Mountpoint) {
-			if err := d.mount(driverhttp.EnvWithLogger(logger, env), volume.Opts, mountPath); err != nil {
-				logger.Error("remount-volume-failed", err)
-				return dockerdriver.MountResponse{Err: fmt.Sprintf("Error remounting volume: %s", err.Error())}
+		if volume.Degraded || !d.mounter.Check(driverhttp.EnvWithLogger(logger, env), volume.Name, volume.Mountpoint) {
+			remountStartTime := d.time.Now()
+			err := d.mount(driverhttp.EnvWithLogger(logger, env), volume.Opts, mountPath)
+			d.recordMount(volume.Name, remountStartTime, d.time.Now(), err)
+			d.metrics.ObserveRemount(metrics.RemountOnMount, outcomeOf(err))
+			if err != nil {
+				logger.Error("remount-volume-failed", d.redactor.Error(err))
+				return dockerdriver.MountResponse{Err: d.errorText(&drivererrors.StaleMountError{Volume: volume.Name, Err: d.mountError(env, volume.Name, mountPath, err)})}
 			}
 		}
-		return dockerdriver.MountResponse{Mountpoint: volume.Mountpoint}
+	}
+
+	callers := d.mountFlights.close(mountRequest.Name, flight)
+	added := 0
+	for _, id := range callers {
+		if addReference(&volume, id) {
+			added++
+		} else {
+			logger.Info("mount-id-already-holds-reference", lager.Data{"mount-id": id})
+		}
+	}
+	volume.Degraded = false
+	if volume.TeardownAt != nil {
+		logger.Info("reused-lingering-mount")
+		volume.TeardownAt = nil
+	}
+	logger.Info("volume-ref-count-incremented", lager.Data{"name": volume.Name, "count": volume.MountCount, "callers": len(callers), "added": added})
+
+	d.volumes.Put(mountRequest.Name, volume)
+	if err := d.persistVolume(driverhttp.EnvWithLogger(logger, env), mountRequest.Name); err != nil {
+		logger.Error("persist-state-failed", err)
+		d.rollbackMount(driverhttp.EnvWithLogger(logger, env), previous, mountPath, doMount)
+		return dockerdriver.MountResponse{Err: d.errorText(&drivererrors.StatePersistenceError{Volume: mountRequest.Name, Operation: "mounting", Err: err})}
+	}
+	if lingering(previous) {
+		d.cancelTeardown(mountRequest.Name)
+	}
+
+	return dockerdriver.MountResponse{Mountpoint: volume.Mountpoint}
+}
+
+// rollbackMount restores the in-memory state of a volume after its mount
+// could not be committed. The kernel mount is only torn down when this
+// request created it; a mount shared with earlier callers is left in place.
+func (d *VolumeDriver) rollbackMount(env dockerdriver.Env, previous NfsVolumeInfo, mountPath string, unmount bool) {
+	logger := env.Logger().Session("rollback-mount")
+	logger.Info("start")
+	defer logger.Info("end")
+
+	d.volumes.Put(previous.Name, previous)
+	logger.Info("volume-ref-count-restored", lager.Data{"name": previous.Name, "count": previous.MountCount})
+
+	if !unmount {
+		return
+	}
+
+	if err := d.unmount(env, previous.Name, mountPath); err != nil {
+		logger.Error("rollback-unmount-failed", d.redactor.Error(err), lager.Data{"mountpoint": mountPath})
 	}
 }
 
-func (d *VolumeDriver) Path(env dockerdriver.Env, pathRequest dockerdriver.PathRequest) dockerdriver.PathResponse {
+func (d *VolumeDriver) Path(env dockerdriver.Env, pathRequest dockerdriver.PathRequest) (response dockerdriver.PathResponse) {
+	defer d.observeOperation("path", d.time.Now(), &response.Err)
+
 	logger := env.Logger().Session("path", lager.Data{"volume": pathRequest.Name})
 
 	if pathRequest.Name == "" {
-		return dockerdriver.PathResponse{Err: "Missing mandatory 'volume_name'"}
+		return dockerdriver.PathResponse{Err: d.errorText(&drivererrors.InvalidNameError{})}
 	}
 	if err := validateVolumeName(pathRequest.Name); err != nil {
-		return dockerdriver.PathResponse{Err: err.Error()}
+		return dockerdriver.PathResponse{Err: d.errorText(err)}
 	}
 
 	vol, err := d.getVolume(driverhttp.EnvWithLogger(logger, env), pathRequest.Name)
 	if err != nil {
 		logger.Error("failed-no-such-volume-found", err, lager.Data{"mountpoint": vol.Mountpoint})
 
-		return dockerdriver.PathResponse{Err: fmt.Sprintf("Volume '%s' not found", pathRequest.Name)}
+		return dockerdriver.PathResponse{Err: d.errorText(err)}
 	}
 
 	if vol.Mountpoint == "" {
-		errText := "volume not previously mounted"
-		logger.Error("failed-mountpoint-not-assigned", errors.New(errText))
-		return dockerdriver.PathResponse{Err: errText}
+		err := &drivererrors.NotMountedError{Volume: pathRequest.Name}
+		logger.Error("failed-mountpoint-not-assigned", err)
+		return dockerdriver.PathResponse{Err: d.errorText(err)}
 	}
 
 	return dockerdriver.PathResponse{Mountpoint: vol.Mountpoint}
 }
 
 // decrementMountCount decrements the mount count for a volume and updates or removes it from the state.
-// If the mount count reaches 0, the volume is removed from the volumes map.
-func (d *VolumeDriver) decrementMountCount(logger lager.Logger, volumeName string) {
+// If the mount count reaches 0, the volume is removed from the volumes map, unless teardownAt is set
+// to keep its mount until then.
+func (d *VolumeDriver) decrementMountCount(logger lager.Logger, volumeName, callerID string, teardownAt *time.Time) {
 	volume, ok := d.volumes.Get(volumeName)
 	if !ok {
 		return
 	}
 
-	volume.MountCount--
+	releaseReference(logger, &volume, callerID)
 	logger.Info("volume-ref-count-decremented", lager.Data{"name": volume.Name, "count": volume.MountCount})
 
-	switch volume.MountCount {
-	case 0:
+	switch {
+	case volume.MountCount == 0 && teardownAt != nil:
+		volume.TeardownAt = teardownAt
+		d.volumes.Put(volumeName, volume)
+	case volume.MountCount == 0:
 		d.volumes.Delete(volumeName)
+		d.mountRecords.Delete(volumeName)
 	default:
 		d.volumes.Put(volumeName, volume)
 	}
 }
 
-func (d *VolumeDriver) Unmount(env dockerdriver.Env, unmountRequest dockerdriver.UnmountRequest) dockerdriver.ErrorResponse {
+func (d *VolumeDriver) Unmount(env dockerdriver.Env, unmountRequest dockerdriver.UnmountRequest) (response dockerdriver.ErrorResponse) {
+	defer d.observeOperation("unmount", d.time.Now(), &response.Err)
+
 	logger := env.Logger().Session("unmount", lager.Data{"volume": unmountRequest.Name})
 	logger.Info("start")
 	defer logger.Info("end")
 
 	if unmountRequest.Name == "" {
-		return dockerdriver.ErrorResponse{Err: "Missing mandatory 'volume_name'"}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidNameError{})}
 	}
 	if err := validateVolumeName(unmountRequest.Name); err != nil {
-		return dockerdriver.ErrorResponse{Err: err.Error()}
+		return dockerdriver.ErrorResponse{Err: d.errorText(err)}
 	}
 
+	unlock := d.volumeLocks.Lock(unmountRequest.Name)
+	defer unlock()
+
+	callerID := CallerID(env.Context())
 	volume, ok := d.volumes.Get(unmountRequest.Name)
+	if !ok && callerID != "" {
+		// The final Unmount removes the volume, so a retry of it finds nothing.
+		logger.Info("mount-id-retried-after-removal", lager.Data{"mount-id": callerID})
+		return dockerdriver.ErrorResponse{}
+	}
 	if !ok {
 		logger.Error("failed-no-such-volume-found", fmt.Errorf("could not find volume %s", unmountRequest.Name))
 
-		return dockerdriver.ErrorResponse{Err: fmt.Sprintf("Volume '%s' not found", unmountRequest.Name)}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.NotFoundError{Volume: unmountRequest.Name})}
+	}
+
+	if callerID != "" && !holdsReference(volume, callerID) {
+		// Most likely a retry of an Unmount that has already been applied.
+		logger.Info("mount-id-holds-no-reference", lager.Data{"mount-id": callerID})
+		return dockerdriver.ErrorResponse{}
 	}
 
-	if volume.Mountpoint == "" {
-		errText := "volume not previously mounted"
-		logger.Error("failed-mountpoint-not-assigned", errors.New(errText))
-		return dockerdriver.ErrorResponse{Err: errText}
+	if volume.Mountpoint == "" || lingering(volume) {
+		err := &drivererrors.NotMountedError{Volume: unmountRequest.Name}
+		logger.Error("failed-mountpoint-not-assigned", err)
+		return dockerdriver.ErrorResponse{Err: d.errorText(err)}
 	}
 
 	var unmountErr error
+	var teardownAt *time.Time
 	if volume.MountCount == 1 {
-		unmountErr = d.unmount(driverhttp.EnvWithLogger(logger, env), unmountRequest.Name, volume.Mountpoint)
+		if d.unmountLinger > 0 {
+			at := d.clock.Now().Add(d.unmountLinger)
+			teardownAt = &at
+		} else {
+			unmountErr = d.unmount(driverhttp.EnvWithLogger(logger, env), unmountRequest.Name, volume.Mountpoint)
+		}
 	}
 
 	// Always decrement the mount count, even if unmount failed
-	d.decrementMountCount(logger, unmountRequest.Name)
+	d.decrementMountCount(logger, unmountRequest.Name, callerID, teardownAt)
+	if teardownAt != nil {
+		d.scheduleTeardown(logger, unmountRequest.Name, *teardownAt)
+	}
 
 	// Persist state after decrementing (even if unmount failed)
-	if err := d.persistState(driverhttp.EnvWithLogger(logger, env)); err != nil {
-		return dockerdriver.ErrorResponse{Err: fmt.Sprintf("failed to persist state when unmounting: %s", err.Error())}
+	if err := d.persistVolume(driverhttp.EnvWithLogger(logger, env), unmountRequest.Name); err != nil {
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.StatePersistenceError{Volume: unmountRequest.Name, Operation: "unmounting", Err: err})}
 	}
 
 	// If unmount failed, return error after decrementing and persisting
@@ -312,24 +525,29 @@ func (d *VolumeDriver) Unmount(env dockerdriver.Env, unmountRequest dockerdriver
 		if errors.As(unmountErr, &mountPointNotExistErr) {
 			logger.Info("mountpoint-not-exist-return-error", lager.Data{"volume": unmountRequest.Name, "mountpath": volume.Mountpoint})
 		}
-		return dockerdriver.ErrorResponse{Err: unmountErr.Error()}
+		return dockerdriver.ErrorResponse{Err: d.errorText(unmountErr)}
 	}
 
 	return dockerdriver.ErrorResponse{}
 }
 
-func (d *VolumeDriver) Remove(env dockerdriver.Env, removeRequest dockerdriver.RemoveRequest) dockerdriver.ErrorResponse {
+func (d *VolumeDriver) Remove(env dockerdriver.Env, removeRequest dockerdriver.RemoveRequest) (response dockerdriver.ErrorResponse) {
+	defer d.observeOperation("remove", d.time.Now(), &response.Err)
+
 	logger := env.Logger().Session("remove", lager.Data{"volume": removeRequest})
 	logger.Info("start")
 	defer logger.Info("end")
 
 	if removeRequest.Name == "" {
-		return dockerdriver.ErrorResponse{Err: "Missing mandatory 'volume_name'"}
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.InvalidNameError{})}
 	}
 	if err := validateVolumeName(removeRequest.Name); err != nil {
-		return dockerdriver.ErrorResponse{Err: err.Error()}
+		return dockerdriver.ErrorResponse{Err: d.errorText(err)}
 	}
 
+	unlock := d.volumeLocks.Lock(removeRequest.Name)
+	defer unlock()
+
 	vol, err := d.getVolume(driverhttp.EnvWithLogger(logger, env), removeRequest.Name)
 
 	if err != nil {
@@ -339,29 +557,33 @@ func (d *VolumeDriver) Remove(env dockerdriver.Env, removeRequest dockerdriver.R
 
 	if vol.Mountpoint != "" {
 		if err := d.unmount(driverhttp.EnvWithLogger(logger, env), removeRequest.Name, vol.Mountpoint); err != nil {
-			return dockerdriver.ErrorResponse{Err: err.Error()}
+			return dockerdriver.ErrorResponse{Err: d.errorText(err)}
 		}
 	}
 
 	logger.Info("removing-volume", lager.Data{"name": removeRequest.Name})
 
 	d.volumes.Delete(removeRequest.Name)
+	d.mountRecords.Delete(removeRequest.Name)
+	d.cancelTeardown(removeRequest.Name)
 
-	if err := d.persistState(driverhttp.EnvWithLogger(logger, env)); err != nil {
-		return dockerdriver.ErrorResponse{Err: fmt.Sprintf("failed to persist state when removing: %s", err.Error())}
+	if err := d.persistVolume(driverhttp.EnvWithLogger(logger, env), removeRequest.Name); err != nil {
+		return dockerdriver.ErrorResponse{Err: d.errorText(&drivererrors.StatePersistenceError{Volume: removeRequest.Name, Operation: "removing", Err: err})}
 	}
 
 	return dockerdriver.ErrorResponse{}
 }
 
-func (d *VolumeDriver) Get(env dockerdriver.Env, getRequest dockerdriver.GetRequest) dockerdriver.GetResponse {
+func (d *VolumeDriver) Get(env dockerdriver.Env, getRequest dockerdriver.GetRequest) (response dockerdriver.GetResponse) {
+	defer d.observeOperation("get", d.time.Now(), &response.Err)
+
 	if err := validateVolumeName(getRequest.Name); err != nil {
-		return dockerdriver.GetResponse{Err: err.Error()}
+		return dockerdriver.GetResponse{Err: d.errorText(err)}
 	}
 
 	volume, err := d.getVolume(env, getRequest.Name)
 	if err != nil {
-		return dockerdriver.GetResponse{Err: err.Error()}
+		return dockerdriver.GetResponse{Err: d.errorText(err)}
 	}
 
 	return dockerdriver.GetResponse{
@@ -380,10 +602,12 @@ func (d *VolumeDriver) getVolume(env dockerdriver.Env, volumeName string) (NfsVo
 		return vol, nil
 	}
 
-	return NfsVolumeInfo{}, errors.New("volume not found")
+	return NfsVolumeInfo{}, &drivererrors.NotFoundError{Volume: volumeName}
 }
 
 func (d *VolumeDriver) Capabilities(env dockerdriver.Env) dockerdriver.CapabilitiesResponse {
+	defer d.observeOperation("capabilities", d.time.Now(), new(string))
+
 	return dockerdriver.CapabilitiesResponse{
 		Capabilities: dockerdriver.CapabilityInfo{Scope: "local"},
 	}
@@ -391,15 +615,12 @@ func (d *VolumeDriver) Capabilities(env dockerdriver.Env) dockerdriver.Capabilit
 
 func (d *VolumeDriver) mountPath(env dockerdriver.Env, volumeId string) string {
 	logger := env.Logger().Session("mount-path")
-	orig := d.osHelper.Umask(000)
-	defer d.osHelper.Umask(orig)
-
 	dir, err := d.filepath.Abs(d.mountPathRoot)
 	if err != nil {
 		logger.Fatal("abs-failed", err)
 	}
 
-	if err := d.os.MkdirAll(dir, os.ModePerm); err != nil {
+	if err := mkdirAll(d.os, dir, d.permissions.MountRoot); err != nil {
 		logger.Fatal("mkdir-rootpath-failed", err)
 	}
 
@@ -408,7 +629,7 @@ func (d *VolumeDriver) mountPath(env dockerdriver.Env, volumeId string) string {
 
 func (d *VolumeDriver) mount(env dockerdriver.Env, opts map[string]interface{}, mountPath string) error {
 	source, sourceOk := opts["source"].(string)
-	logger := env.Logger().Session("mount", lager.Data{"source": source, "target": mountPath})
+	logger := env.Logger().Session("mount", lager.Data{"source": d.redactor.String(source), "target": mountPath})
 	logger.Info("start")
 	defer logger.Info("end")
 
@@ -418,18 +639,19 @@ func (d *VolumeDriver) mount(env dockerdriver.Env, opts map[string]interface{},
 		return err
 	}
 
-	orig := d.osHelper.Umask(000)
-	defer d.osHelper.Umask(orig)
-
-	err := d.os.MkdirAll(mountPath, os.ModePerm)
+	err := d.os.MkdirAll(mountPath, d.permissions.MountDir.Mode)
 	if err != nil {
 		logger.Error("create-mountdir-failed", err)
 		return err
 	}
+	if err := applyFileSpec(d.os, mountPath, d.permissions.MountDir); err != nil {
+		logger.Error("set-mountdir-permissions-failed", err)
+		return err
+	}
 
-	err = d.mounter.Mount(env, source, mountPath, opts)
+	err = d.mountWithTimeout(env, logger, source, mountPath, opts)
 	if err != nil {
-		logger.Error("mount-failed: ", err)
+		logger.Error("mount-failed: ", d.redactor.Error(err))
 		rm_err := d.os.Remove(mountPath)
 		if rm_err != nil {
 			logger.Error("mountpoint-remove-failed", rm_err, lager.Data{"mount-path": mountPath})
@@ -443,49 +665,103 @@ func (d *VolumeDriver) persistState(env dockerdriver.Env) error {
 	logger.Info("start")
 	defer logger.Info("end")
 
-	orig := d.osHelper.Umask(000)
-	defer d.osHelper.Umask(orig)
+	if d.stateRefused != nil {
+		logger.Error("state-writes-blocked", d.stateRefused)
+		return d.stateRefused
+	}
 
-	stateFile := d.mountPath(env, "driver-state.json")
+	// Serialise writers so that an older snapshot can never overwrite a newer one.
+	d.persistLock.Lock()
+	defer d.persistLock.Unlock()
 
-	stateData, err := json.Marshal(d.volumes)
-	if err != nil {
-		logger.Error("failed-to-marshall-state", err)
+	if err := d.stateStore.Save(logger, d.volumes.Copy()); err != nil {
+		d.metrics.IncPersistFailures()
 		return err
 	}
 
-	err = d.os.WriteFile(stateFile, stateData, os.ModePerm)
+	return nil
+}
+
+// persistVolume records a change to a single volume, which is deleted from
+// the store if it is no longer known. Stores that cannot record a single
+// volume save the whole state instead.
+func (d *VolumeDriver) persistVolume(env dockerdriver.Env, name string) error {
+	store, ok := d.stateStore.(VolumeStateStore)
+	if !ok {
+		return d.persistState(env)
+	}
+
+	logger := env.Logger().Session("persist-volume", lager.Data{"volume": name})
+	logger.Info("start")
+	defer logger.Info("end")
+
+	if d.stateRefused != nil {
+		logger.Error("state-writes-blocked", d.stateRefused)
+		return d.stateRefused
+	}
+
+	d.persistLock.Lock()
+	defer d.persistLock.Unlock()
+
+	var err error
+	if volume, exists := d.volumes.Get(name); exists {
+		err = store.PutVolume(logger, volume)
+	} else {
+		err = store.DeleteVolume(logger, name)
+	}
 	if err != nil {
-		logger.Error("failed-to-write-state-file", err, lager.Data{"stateFile": stateFile})
+		d.metrics.IncPersistFailures()
 		return err
 	}
 
-	logger.Debug("state-saved", lager.Data{"state-file": stateFile})
 	return nil
 }
 
-func (d *VolumeDriver) restoreState(env dockerdriver.Env) {
+// restoreState loads the persisted volumes. It returns an error if there was
+// state that could not be loaded; having none to load is not an error.
+func (d *VolumeDriver) restoreState(env dockerdriver.Env) error {
 	logger := env.Logger().Session("restore-state")
 	logger.Info("start")
 	defer logger.Info("end")
 
-	stateFile := filepath.Join(d.mountPathRoot, "driver-state.json")
-
-	stateData, err := d.os.ReadFile(stateFile)
+	volumes, err := d.stateStore.Load(logger)
 	if err != nil {
-		logger.Info("failed-to-read-state-file", lager.Data{"err": err, "stateFile": stateFile})
-		return
+		var unsupported *UnsupportedStateVersionError
+		if errors.As(err, &unsupported) {
+			logger.Error("unsupported-state-version", err, lager.Data{"version": unsupported.Version, "driver-version": unsupported.DriverVersion})
+			// Saving would move the newer state to the backup, and a second save
+			// would overwrite it.
+			d.stateRefused = err
+			return err
+		}
+		logger.Info("failed-to-restore-state", lager.Data{"err": err})
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
 	}
-	logger.Info("state", lager.Data{"state": string(stateData)})
 
-	if err := json.Unmarshal(stateData, d.volumes); err != nil {
-		logger.Error("failed-to-unmarshall-state", err, lager.Data{"stateFile": stateFile})
-		return
+	if stateData, err := json.Marshal(volumes); err == nil {
+		logger.Info("state", lager.Data{"state": d.redactor.String(string(stateData))})
+	}
+
+	for name, volume := range volumes {
+		volume.Opts = d.openOpts(logger, volume.PersistedOpts)
+		d.volumes.Put(name, volume)
 	}
-	logger.Info("state-restored", lager.Data{"state-file": stateFile})
+	logger.Info("state-restored", lager.Data{"volumes": len(volumes)})
+	return nil
 }
 
 func (d *VolumeDriver) unmount(env dockerdriver.Env, name string, mountPath string) error {
+	_, err := d.unmountVolume(env, name, mountPath)
+	return err
+}
+
+// unmountVolume unmounts mountPath and removes it, escalating to a forced or
+// lazy unmount if a normal one fails. forced reports whether it had to. The
+// directory is only removed once the mount has gone from the mount table.
+func (d *VolumeDriver) unmountVolume(env dockerdriver.Env, name string, mountPath string) (forced bool, err error) {
 	logger := env.Logger().Session("unmount")
 	logger.Info("start")
 	defer logger.Info("end")
@@ -493,7 +769,7 @@ func (d *VolumeDriver) unmount(env dockerdriver.Env, name string, mountPath stri
 	exists, err := d.mountChecker.Exists(mountPath)
 	if err != nil {
 		logger.Error("failed-proc-mounts-check", err, lager.Data{"mountpoint": mountPath})
-		return err
+		return false, &drivererrors.UnmountFailedError{Volume: name, Err: err}
 	}
 
 	if !exists {
@@ -501,62 +777,50 @@ func (d *VolumeDriver) unmount(env dockerdriver.Env, name string, mountPath stri
 		if err != nil {
 			var mountPointNotExistErr *MountPointNotExistError
 			if errors.As(err, &mountPointNotExistErr) {
-				return err
+				return false, &drivererrors.NotMountedError{Volume: name, Mountpoint: mountPath, Err: err}
 			}
 			errText := fmt.Sprintf("Volume %s does not exist (path: %s) and unable to remove mount directory", name, mountPath)
 			logger.Info("mountpoint-not-found", lager.Data{"msg": errText})
-			return fmt.Errorf("%s: %w", errText, err)
+			return false, &drivererrors.UnmountFailedError{Volume: name, Err: fmt.Errorf("%s: %w", errText, err)}
 		}
 
 		errText := fmt.Sprintf("Volume %s does not exist (path: %s)", name, mountPath)
 		logger.Info("mountpoint-not-found", lager.Data{"msg": errText})
-		return errors.New(errText)
+		return false, &drivererrors.NotMountedError{Volume: name, Mountpoint: mountPath, Err: errors.New(errText)}
 	}
 
 	logger.Info("unmount-volume-folder", lager.Data{"mountpath": mountPath})
 
-	err = d.mounter.Unmount(env, mountPath)
+	err = d.unmountNormally(env, mountPath)
+	if err == nil && d.stillMounted(logger, mountPath) {
+		err = errors.New("still mounted after unmount")
+	}
 	if err != nil {
+		err = d.redactor.Error(err)
 		logger.Error("unmount-failed", err)
-		return fmt.Errorf("error unmounting volume: %w", err)
+		escalateErr := d.escalateUnmount(env, name, mountPath)
+		switch {
+		case errors.Is(escalateErr, errFlagUnmountUnsupported):
+			return false, &drivererrors.UnmountFailedError{Volume: name, Err: fmt.Errorf("error unmounting volume: %w", err)}
+		case escalateErr != nil:
+			return false, &drivererrors.UnmountFailedError{Volume: name, Err: fmt.Errorf("error unmounting volume: %w (escalation failed: %w)", err, d.redactor.Error(escalateErr))}
+		}
+		forced = true
 	}
 
 	err = d.removeMountPath(logger, name, mountPath, "after-unmount")
 	if err != nil {
 		var mountPointNotExistErr *MountPointNotExistError
 		if errors.As(err, &mountPointNotExistErr) {
-			return err
+			return forced, &drivererrors.NotMountedError{Volume: name, Mountpoint: mountPath, Err: err}
 		}
 		logger.Error("remove-mountpoint-failed", err)
-		return fmt.Errorf("error removing mountpoint: %w", err)
-	}
-
-	logger.Info("unmounted-volume")
-
-	return nil
-}
-
-func (d *VolumeDriver) Drain(env dockerdriver.Env) error {
-	logger := env.Logger().Session("check-mounts")
-	logger.Info("start")
-	defer logger.Info("end")
-
-	// flush any volumes that are still in our map
-	for _, key := range d.volumes.Keys() {
-		if mount, ok := d.volumes.Get(key); ok {
-			if mount.Mountpoint != "" && mount.MountCount > 0 {
-				err := d.unmount(env, mount.Name, mount.Mountpoint)
-				if err != nil {
-					logger.Error("drain-unmount-failed", err, lager.Data{"mount-name": mount.Name, "mount-point": mount.Mountpoint})
-				}
-			}
-			d.volumes.Delete(key)
-		}
+		return forced, &drivererrors.UnmountFailedError{Volume: name, Err: fmt.Errorf("error removing mountpoint: %w", err)}
 	}
 
-	d.mounter.Purge(env, d.mountPathRoot)
+	logger.Info("unmounted-volume", lager.Data{"forced": forced})
 
-	return nil
+	return forced, nil
 }
 
 func copyOpts(input map[string]any) map[string]any {
@@ -567,9 +831,39 @@ func copyOpts(input map[string]any) map[string]any {
 	return output
 }
 
+// errorText is what a response's Err field says about err. Secrets are
+// redacted from the message before it leaves the driver.
+func (d *VolumeDriver) errorText(err error) string {
+	return drivererrors.Encode(d.redactor.Error(err))
+}
+
+// mountError classifies an error from mounting a volume.
+func (d *VolumeDriver) mountError(env dockerdriver.Env, name, mountPath string, err error) error {
+	var typed drivererrors.Error
+	if errors.As(err, &typed) {
+		return err
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(env.Context().Err(), context.DeadlineExceeded) ||
+		mountclassifier.CategoryOf(err) == mountclassifier.CategoryTimeout {
+		return &drivererrors.MountTimeoutError{Volume: name, Err: err}
+	}
+
+	if errors.Is(err, os.ErrPermission) {
+		path := mountPath
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			path = pathErr.Path
+		}
+		return &drivererrors.PermissionDeniedError{Volume: name, Path: path, Err: err}
+	}
+
+	return &drivererrors.MountFailedError{Volume: name, Err: err}
+}
+
 func validateVolumeName(name string) error {
 	if strings.Contains(name, "..") || strings.Contains(name, "/") || strings.Contains(name, "\\") {
-		return fmt.Errorf("invalid volume name: %s", name)
+		return &drivererrors.InvalidNameError{Volume: name}
 	}
 	return nil
 }
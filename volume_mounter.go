@@ -1,6 +1,8 @@
 package volumedriver
 
 import (
+	"time"
+
 	"code.cloudfoundry.org/dockerdriver"
 )
 
@@ -12,3 +14,23 @@ type Mounter interface {
 	Check(env dockerdriver.Env, name, mountPoint string) bool
 	Purge(env dockerdriver.Env, path string)
 }
+
+// UnmountFlags ask for an unmount that does not wait for a busy or
+// unreachable mount.
+type UnmountFlags struct {
+	// Force aborts requests still pending against the server (MNT_FORCE).
+	Force bool
+	// Lazy detaches the mount straight away and cleans it up once it is no
+	// longer busy (MNT_DETACH).
+	Lazy bool
+	// Timeout bounds the unmount, if set.
+	Timeout time.Duration
+}
+
+// FlagUnmounter is implemented by Mounters that can force or lazily detach a
+// mount. The driver falls back to it when a normal unmount fails.
+//
+//counterfeiter:generate -o volumedriverfakes/fake_flag_unmounter.go . FlagUnmounter
+type FlagUnmounter interface {
+	UnmountWithFlags(env dockerdriver.Env, target string, flags UnmountFlags) error
+}
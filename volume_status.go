@@ -0,0 +1,164 @@
+package volumedriver
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// VolumeStatus is a detailed view of a volume, for debugging. Sensitive
+// options are redacted.
+type VolumeStatus struct {
+	Name       string
+	Mountpoint string                 `json:",omitempty"`
+	Source     string                 `json:",omitempty"`
+	Opts       map[string]interface{} `json:",omitempty"`
+	MountCount int
+	MountIDs   []string `json:",omitempty"`
+	Degraded   bool     `json:",omitempty"`
+	// TeardownAt is when a mount kept after its last Unmount will be torn
+	// down.
+	TeardownAt *time.Time `json:",omitempty"`
+
+	// FirstMounted and LastMounted are when the volume was first and most
+	// recently mounted successfully since the driver started.
+	FirstMounted *time.Time `json:",omitempty"`
+	LastMounted  *time.Time `json:",omitempty"`
+	// LastMountDuration is how long the most recent mount took, whether or
+	// not it succeeded.
+	LastMountDuration time.Duration `json:",omitempty"`
+	// LastError is the error from the most recent failed mount, and is kept
+	// after later mounts succeed.
+	LastError   string     `json:",omitempty"`
+	LastErrorAt *time.Time `json:",omitempty"`
+
+	// Health is the last result from the health monitor, if it has checked
+	// the volume.
+	Health *VolumeHealth `json:",omitempty"`
+
+	// KernelMounted reports whether the MountChecker finds the mountpoint
+	// in the kernel's mount table.
+	KernelMounted    bool
+	KernelMountError string `json:",omitempty"`
+}
+
+// VolumeStatusReporter is implemented by drivers that can report detailed
+// volume status.
+type VolumeStatusReporter interface {
+	VolumeStatus(name string) (VolumeStatus, bool)
+	VolumesStatus() map[string]VolumeStatus
+}
+
+// mountRecord is the mount history of a volume since the driver started.
+type mountRecord struct {
+	firstMounted      time.Time
+	lastMounted       time.Time
+	lastMountDuration time.Duration
+	lastError         string
+	lastErrorAt       time.Time
+}
+
+// VolumeStatus returns the detailed status of a volume.
+func (d *VolumeDriver) VolumeStatus(name string) (VolumeStatus, bool) {
+	volume, ok := d.volumes.Get(name)
+	if !ok {
+		return VolumeStatus{}, false
+	}
+
+	source, _ := volume.Opts["source"].(string)
+	status := VolumeStatus{
+		Name:       volume.Name,
+		Mountpoint: volume.Mountpoint,
+		Source:     d.redactor.String(source),
+		Opts:       d.redactor.Opts(volume.Opts),
+		MountCount: volume.MountCount,
+		MountIDs:   volume.MountIDs,
+		Degraded:   volume.Degraded,
+		TeardownAt: volume.TeardownAt,
+	}
+
+	if record, ok := d.mountRecords.Get(name); ok {
+		status.FirstMounted = timeOrNil(record.firstMounted)
+		status.LastMounted = timeOrNil(record.lastMounted)
+		status.LastMountDuration = record.lastMountDuration
+		status.LastError = record.lastError
+		status.LastErrorAt = timeOrNil(record.lastErrorAt)
+	}
+
+	if health, ok := d.health.Get(name); ok {
+		status.Health = &health
+	}
+
+	if volume.Mountpoint != "" {
+		exists, err := d.mountChecker.Exists(volume.Mountpoint)
+		if err != nil {
+			status.KernelMountError = err.Error()
+		}
+		status.KernelMounted = exists
+	}
+
+	return status, true
+}
+
+// VolumesStatus returns the detailed status of every volume.
+func (d *VolumeDriver) VolumesStatus() map[string]VolumeStatus {
+	result := map[string]VolumeStatus{}
+	for _, name := range d.volumes.Keys() {
+		if status, ok := d.VolumeStatus(name); ok {
+			result[name] = status
+		}
+	}
+	return result
+}
+
+// recordMount adds a mount attempt that ran from start to end to the
+// volume's history.
+func (d *VolumeDriver) recordMount(name string, start, end time.Time, err error) {
+	record, _ := d.mountRecords.Get(name)
+	record.lastMountDuration = end.Sub(start)
+	if err != nil {
+		record.lastError = d.redactor.String(err.Error())
+		record.lastErrorAt = end
+	} else {
+		if record.firstMounted.IsZero() {
+			record.firstMounted = end
+		}
+		record.lastMounted = end
+	}
+	d.mountRecords.Put(name, record)
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// NewStatusHandler serves the status of every volume as JSON, or of a single
+// volume when the request has a "volume" query parameter. It is intended for
+// an admin listener, not the docker plugin API.
+func NewStatusHandler(reporter VolumeStatusReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var body interface{}
+		if name := r.URL.Query().Get("volume"); name != "" {
+			status, ok := reporter.VolumeStatus(name)
+			if !ok {
+				http.Error(w, "volume not found", http.StatusNotFound)
+				return
+			}
+			body = status
+		} else {
+			body = reporter.VolumesStatus()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,116 @@
+// Code generated by counterfeiter. DO NOT EDIT.
+package volumedriverfakes
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/dockerdriver"
+	"code.cloudfoundry.org/volumedriver"
+)
+
+type FakeFlagUnmounter struct {
+	UnmountWithFlagsStub        func(dockerdriver.Env, string, volumedriver.UnmountFlags) error
+	unmountWithFlagsMutex       sync.RWMutex
+	unmountWithFlagsArgsForCall []struct {
+		arg1 dockerdriver.Env
+		arg2 string
+		arg3 volumedriver.UnmountFlags
+	}
+	unmountWithFlagsReturns struct {
+		result1 error
+	}
+	unmountWithFlagsReturnsOnCall map[int]struct {
+		result1 error
+	}
+	invocations      map[string][][]interface{}
+	invocationsMutex sync.RWMutex
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlags(arg1 dockerdriver.Env, arg2 string, arg3 volumedriver.UnmountFlags) error {
+	fake.unmountWithFlagsMutex.Lock()
+	ret, specificReturn := fake.unmountWithFlagsReturnsOnCall[len(fake.unmountWithFlagsArgsForCall)]
+	fake.unmountWithFlagsArgsForCall = append(fake.unmountWithFlagsArgsForCall, struct {
+		arg1 dockerdriver.Env
+		arg2 string
+		arg3 volumedriver.UnmountFlags
+	}{arg1, arg2, arg3})
+	stub := fake.UnmountWithFlagsStub
+	fakeReturns := fake.unmountWithFlagsReturns
+	fake.recordInvocation("UnmountWithFlags", []interface{}{arg1, arg2, arg3})
+	fake.unmountWithFlagsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlagsCallCount() int {
+	fake.unmountWithFlagsMutex.RLock()
+	defer fake.unmountWithFlagsMutex.RUnlock()
+	return len(fake.unmountWithFlagsArgsForCall)
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlagsCalls(stub func(dockerdriver.Env, string, volumedriver.UnmountFlags) error) {
+	fake.unmountWithFlagsMutex.Lock()
+	defer fake.unmountWithFlagsMutex.Unlock()
+	fake.UnmountWithFlagsStub = stub
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlagsArgsForCall(i int) (dockerdriver.Env, string, volumedriver.UnmountFlags) {
+	fake.unmountWithFlagsMutex.RLock()
+	defer fake.unmountWithFlagsMutex.RUnlock()
+	argsForCall := fake.unmountWithFlagsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlagsReturns(result1 error) {
+	fake.unmountWithFlagsMutex.Lock()
+	defer fake.unmountWithFlagsMutex.Unlock()
+	fake.UnmountWithFlagsStub = nil
+	fake.unmountWithFlagsReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeFlagUnmounter) UnmountWithFlagsReturnsOnCall(i int, result1 error) {
+	fake.unmountWithFlagsMutex.Lock()
+	defer fake.unmountWithFlagsMutex.Unlock()
+	fake.UnmountWithFlagsStub = nil
+	if fake.unmountWithFlagsReturnsOnCall == nil {
+		fake.unmountWithFlagsReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.unmountWithFlagsReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *FakeFlagUnmounter) Invocations() map[string][][]interface{} {
+	fake.invocationsMutex.RLock()
+	defer fake.invocationsMutex.RUnlock()
+	fake.unmountWithFlagsMutex.RLock()
+	defer fake.unmountWithFlagsMutex.RUnlock()
+	copiedInvocations := map[string][][]interface{}{}
+	for key, value := range fake.invocations {
+		copiedInvocations[key] = value
+	}
+	return copiedInvocations
+}
+
+func (fake *FakeFlagUnmounter) recordInvocation(key string, args []interface{}) {
+	fake.invocationsMutex.Lock()
+	defer fake.invocationsMutex.Unlock()
+	if fake.invocations == nil {
+		fake.invocations = map[string][][]interface{}{}
+	}
+	if fake.invocations[key] == nil {
+		fake.invocations[key] = [][]interface{}{}
+	}
+	fake.invocations[key] = append(fake.invocations[key], args)
+}
+
+var _ volumedriver.FlagUnmounter = new(FakeFlagUnmounter)